@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// parseAdmins splits a comma separated list of user names
+func parseAdmins(list string) []string {
+	admins := []string{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) > 0 {
+			admins = append(admins, name)
+		}
+	}
+	return admins
+}
+
+// checkAdmin writes an error and returns false if the user of the request is not an admin
+func checkAdmin(w http.ResponseWriter, r *http.Request, admins []string) bool {
+	user, _, ok := r.BasicAuth()
+	if !ok {
+		httpError(w, http.StatusUnauthorized)
+		return false
+	}
+	if !contains(admins, strings.ToLower(user)) {
+		httpError(w, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// duplicateReport lists all groups of maimais that look the same
+// Only admins can see the report.
+func duplicateReport(template template.Template, source MaimaiSource, admins []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdmin(w, r, admins) {
+			return
+		}
+		clusters, err := source.DuplicateClusters()
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if wantsJSON(r) {
+			report := make([][]string, len(clusters))
+			for i, cluster := range clusters {
+				for _, mm := range cluster {
+					report[i] = append(report[i], mm.Permalink())
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+		err = template.Execute(w, struct {
+			Clusters [][]UserMaimai
+		}{
+			Clusters: clusters,
+		})
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// DeleteMaimai removes the file of a maimai together with its poster frame, its metadata
+// and the unmodified upload in originalsDir if it is not empty
+func (m MaimaiSource) DeleteMaimai(mm UserMaimai, originalsDir string) error {
+	uploadLock.Lock()
+	defer uploadLock.Unlock()
+	if err := os.Remove(m.FilePath(mm)); err != nil {
+		return err
+	}
+	ImgCache.Forget(mm.Href())
+	if mm.IsVideo() {
+		poster := filepath.Join(string(m), mm.PosterHref())
+		if err := os.Remove(poster); err != nil && !os.IsNotExist(err) {
+			log.Warnf("cannot remove poster frame of %s: %v", mm.Href(), err)
+		}
+		ImgCache.Forget(mm.PosterHref())
+	}
+	if len(originalsDir) > 0 {
+		if err := os.Remove(filepath.Join(originalsDir, mm.Href())); err != nil && !os.IsNotExist(err) {
+			log.Warnf("cannot remove original of %s: %v", mm.Href(), err)
+		}
+	}
+	return m.UpdateWeekMetadata(mm.CW, func(meta WeekMetadata) error {
+		delete(meta, mm.FileName())
+		return nil
+	})
+}
+
+// deleteMaimai lets admins remove a maimai, e.g. a repost from the duplicate report
+func deleteMaimai(source MaimaiSource, admins []string, originalsDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkAdmin(w, r, admins) {
+			return
+		}
+		maimai, err := findMaimai(source, r)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if maimai == nil {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		if err := source.DeleteMaimai(*maimai, originalsDir); err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		user, _, _ := r.BasicAuth()
+		log.Infof("%s deleted %s", user, maimai.Href())
+		Events.Publish(NewMaimaiEvent(EventDelete, *maimai, user))
+		redirectBack(w, r, "/"+maimai.CW.Path())
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDeleteMaimai(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	writeTestMaimais(t, source, "2021/CW_01/1_hans_1.png", "2021/CW_01/2_fritz_1.png")
+	cw := CW{Year: 2021, Week: 1}
+	if err := MigrateMetadata(source); err != nil {
+		t.Fatal(err)
+	}
+	originals := t.TempDir()
+	writeTestMaimais(t, MaimaiSource(originals), "2021/CW_01/2_fritz_1.png")
+	events, unsubscribe := Events.Subscribe()
+	defer unsubscribe()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/delete", deleteMaimai(source, []string{"hans"}, originals))
+	request := func(method, user, url string) int {
+		req := httptest.NewRequest(method, url, nil)
+		req.SetBasicAuth(user, "")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	tests := []struct {
+		method string
+		user   string
+		url    string
+		status int
+	}{
+		{http.MethodGet, "hans", "/2021/CW_01/2/delete", http.StatusMethodNotAllowed},
+		{http.MethodPost, "fritz", "/2021/CW_01/2/delete", http.StatusForbidden},
+		{http.MethodPost, "hans", "/2021/CW_01/3/delete", http.StatusNotFound},
+		{http.MethodPost, "Hans", "/2021/CW_01/2/delete", http.StatusSeeOther},
+	}
+	for _, test := range tests {
+		if status := request(test.method, test.user, test.url); status != test.status {
+			t.Errorf("expected status %d for %s %s by %s, got %d", test.status, test.method, test.url, test.user, status)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(string(source), "2021/CW_01/2_fritz_1.png")); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(originals, "2021/CW_01/2_fritz_1.png")); !os.IsNotExist(err) {
+		t.Errorf("expected the original to be removed, got %v", err)
+	}
+	meta, err := source.ReadMetadata(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := meta["2_fritz_1.png"]; ok || len(meta) != 1 {
+		t.Errorf("expected only the metadata of the other maimai, got %v", meta)
+	}
+	if e := <-events; e.Type != EventDelete || e.CW != cw || e.Counter != 2 {
+		t.Errorf("expected a delete event, got %+v", e)
+	}
+}
@@ -5,11 +5,9 @@ import (
 	"encoding/base64"
 	"image"
 	"image/jpeg"
-	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
-	"time"
 
 	"github.com/nfnt/resize"
 )
@@ -49,14 +47,13 @@ func (c *PreviewCache) GetImage(imgPath string) (CachedImage, error) {
 	return img.(CachedImage), nil
 }
 
+// Forget removes the image from the cache, e.g. if the file was replaced
+func (c *PreviewCache) Forget(imgPath string) {
+	c.cache.Delete(imgPath)
+}
+
 func (c *PreviewCache) cacheImage(imgPath string) error {
-	filePath := filepath.Join(c.dir, imgPath)
-	imgFile, err := os.OpenFile(filePath, os.O_RDONLY, os.ModePerm)
-	if err != nil {
-		return err
-	}
-	defer imgFile.Close()
-	img, _, err := image.Decode(imgFile)
+	img, err := decodeFrame(filepath.Join(c.dir, imgPath))
 	if err != nil {
 		return err
 	}
@@ -81,10 +78,16 @@ func (c *PreviewCache) cacheImage(imgPath string) error {
 	return nil
 }
 
-// InitCache loads images for current year and last three years into cache
+// InitCache sets the directory of the image cache and fills it
+func InitCache(source MaimaiSource) error {
+	ImgCache.dir = string(source)
+	return FillCache()
+}
+
+// FillCache loads images for current year and last three years into cache
 func FillCache() error {
 
-	year, _ := time.Now().ISOWeek()
+	year := Weeks.Current().Year
 
 	worker := func(jobs <-chan Maimai, wg *sync.WaitGroup) {
 		defer wg.Done()
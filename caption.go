@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// editCaption lets the uploader of a maimai change its caption and alt text
+func editCaption(source MaimaiSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, http.StatusMethodNotAllowed)
+			return
+		}
+		user, _, ok := r.BasicAuth()
+		if !ok {
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+
+		maimai, err := findMaimai(source, r)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if maimai == nil {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		if !maimai.By(user) {
+			httpError(w, http.StatusForbidden)
+			return
+		}
+
+		err = source.UpdateMetadata(maimai.CW, maimai.FileName(), func(m *Metadata) {
+			m.Caption = cleanCaption(r.FormValue("caption"))
+			m.AltText = cleanCaption(r.FormValue("alt"))
+		})
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		Events.Publish(NewMaimaiEvent(EventCaption, *maimai, user))
+		redirectBack(w, r, "/"+maimai.CW.Path())
+	}
+}
+
+// findMaimai looks up the maimai addressed by the mux vars year, week and counter
+// returns nil if the week or the maimai do not exist
+func findMaimai(source MaimaiSource, r *http.Request) (*UserMaimai, error) {
+	week, err := findWeek(source, r)
+	if week == nil || err != nil {
+		return nil, err
+	}
+	counter, _ := strconv.Atoi(mux.Vars(r)["counter"])
+	return week.Maimai(counter), nil
+}
+
+// findWeek reads the week addressed by the mux vars year and week
+// returns nil if the week does not exist
+func findWeek(source MaimaiSource, r *http.Request) (*Week, error) {
+	vars := mux.Vars(r)
+	year, _ := strconv.Atoi(vars["year"])
+	week, _ := strconv.Atoi(vars["week"])
+
+	weekData, err := source.GetMaimaisForCW(CW{Year: year, Week: week})
+	if err != nil {
+		if _, ok := err.(*os.PathError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return weekData, nil
+}
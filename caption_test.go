@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestEditCaption(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	folder := filepath.Join(string(source), "2021", "CW_05")
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "1_hans_1.png"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/caption", editCaption(source))
+
+	tests := []struct {
+		method string
+		user   string
+		url    string
+		status int
+	}{
+		{http.MethodGet, "hans", "/2021/CW_05/1/caption", http.StatusMethodNotAllowed},
+		{http.MethodPost, "", "/2021/CW_05/1/caption", http.StatusUnauthorized},
+		{http.MethodPost, "fritz", "/2021/CW_05/1/caption", http.StatusForbidden},
+		{http.MethodPost, "hans", "/2021/CW_05/2/caption", http.StatusNotFound},
+		{http.MethodPost, "hans", "/2021/CW_06/1/caption", http.StatusNotFound},
+		{http.MethodPost, "Hans", "/2021/CW_05/1/caption", http.StatusSeeOther},
+	}
+	for _, test := range tests {
+		form := url.Values{"caption": {"  Frühling im Lockdown "}, "alt": {"Eine Taube"}}
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(test.user) > 0 {
+			req.SetBasicAuth(test.user, "")
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != test.status {
+			t.Errorf("expected status %d for %s %s by %q, got %d", test.status, test.method, test.url, test.user, resp.Code)
+		}
+	}
+
+	meta, err := source.ReadMetadata(CW{Year: 2021, Week: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := meta["1_hans_1.png"]; m.Caption != "Frühling im Lockdown" || m.AltText != "Eine Taube" {
+		t.Errorf("expected the caption and alt text to be saved, got %+v", m)
+	}
+}
+
+func TestCleanCaption(t *testing.T) {
+	if caption := cleanCaption("  Taube\n"); caption != "Taube" {
+		t.Errorf("expected the caption to be trimmed, got %q", caption)
+	}
+	if caption := cleanCaption(strings.Repeat("ä", maxCaptionLength+10)); len([]rune(caption)) != maxCaptionLength {
+		t.Errorf("expected %d characters, got %d", maxCaptionLength, len([]rune(caption)))
+	}
+}
+
+func TestMaimaiAlt(t *testing.T) {
+	m := UserMaimai{User: "hans", Counter: 1, UserCounter: 1, ImageType: "png"}
+	if m.Title() != "1_hans_1.png" || m.Alt() != "Maimai von hans" {
+		t.Errorf("expected the file name and a generic description, got %q and %q", m.Title(), m.Alt())
+	}
+	m.Meta.Caption = "Taube"
+	if m.Title() != "Taube" || m.Alt() != "Taube" {
+		t.Errorf("expected the caption as title and alt text, got %q and %q", m.Title(), m.Alt())
+	}
+	m.Meta.AltText = "Eine Taube"
+	if m.Alt() != "Eine Taube" {
+		t.Errorf("expected the alt text, got %q", m.Alt())
+	}
+}
+
+func TestCardEditButton(t *testing.T) {
+	templates := loadTemplates("templates")
+	maimai := UserMaimai{User: "hans", Counter: 1, UserCounter: 1, ImageType: "png", CW: CW{Year: 2021, Week: 5}}
+	for user, expected := range map[string]bool{"hans": true, "Hans": true, "fritz": false} {
+		var page bytes.Buffer
+		if err := templates.ExecuteTemplate(&page, "card", Card{Maimai: maimai, User: user}); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(page.String(), "caption-edit") != expected {
+			t.Errorf("expected the edit button for %s: %v", user, expected)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+)
+
+// Sizes of the svg charts in user units, they scale to the width of the page
+const (
+	chartWidth       = 600
+	chartHeight      = 160
+	chartLabelHeight = 16
+	chartRowHeight   = 14
+	chartRowLabels   = 60
+)
+
+// barChart renders a bar chart with a label below every bar as inline svg
+func barChart(labels []string, values []int) template.HTML {
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="chart" viewBox="0 0 %d %d" role="img">`, chartWidth, chartHeight)
+	if len(values) > 0 {
+		slot := float64(chartWidth) / float64(len(values))
+		plot := float64(chartHeight - 2*chartLabelHeight)
+		for i, v := range values {
+			x := slot * float64(i)
+			h := 0.0
+			if max > 0 {
+				h = plot * float64(v) / float64(max)
+			}
+			y := float64(chartHeight-chartLabelHeight) - h
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f"><title>%s: %d</title></rect>`,
+				x+slot*0.1, y, slot*0.8, h, html.EscapeString(labels[i]), v)
+			if v > 0 {
+				fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%d</text>`, x+slot/2, y-3, v)
+			}
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, x+slot/2, chartHeight-3, html.EscapeString(labels[i]))
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// heatmap renders a grid with a row for every label and the opacity of the cells by value as inline svg
+func heatmap(rows []string, values [][]int) template.HTML {
+	max, columns := 0, 0
+	for _, row := range values {
+		if len(row) > columns {
+			columns = len(row)
+		}
+		for _, v := range row {
+			if v > max {
+				max = v
+			}
+		}
+	}
+	height := len(rows)*chartRowHeight + chartLabelHeight
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="chart" viewBox="0 0 %d %d" role="img">`, chartWidth, height)
+	if columns > 0 {
+		cell := float64(chartWidth-chartRowLabels) / float64(columns)
+		for r, label := range rows {
+			y := r * chartRowHeight
+			fmt.Fprintf(&b, `<text x="0" y="%d">%s</text>`, y+chartRowHeight-3, html.EscapeString(label))
+			for c, v := range values[r] {
+				opacity := 0.08
+				if v > 0 {
+					opacity = 0.2 + 0.8*float64(v)/float64(max)
+				}
+				fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="%.1f" height="%d" opacity="%.2f"><title>%s, %d: %d</title></rect>`,
+					float64(chartRowLabels)+cell*float64(c)+0.5, y+1, cell-1, chartRowHeight-2, opacity, html.EscapeString(label), c+1, v)
+			}
+		}
+		// label every tenth column
+		for c := 0; c < columns; c += 10 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d">%d</text>`, float64(chartRowLabels)+cell*float64(c), height-3, c+1)
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
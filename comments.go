@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// commentEditWindow is the time after posting in which a comment can be edited
+const commentEditWindow = 15 * time.Minute
+
+// maxCommentLength limits the number of characters of a comment
+const maxCommentLength = 1000
+
+var mentionRegex = regexp.MustCompile(`@([a-zA-Z]+)`)
+
+// Comment is a message posted on a maimai
+type Comment struct {
+	ID      int       `json:"id"`
+	Author  UserName  `json:"author"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+	Edited  time.Time `json:"edited"`
+}
+
+// HTML returns the escaped comment text with mentions of known users linked to their page
+// of the year the maimai belongs to
+func (c Comment) HTML(users []string, year int) template.HTML {
+	escaped := html.EscapeString(c.Text)
+	linked := mentionRegex.ReplaceAllStringFunc(escaped, func(mention string) string {
+		name := strings.ToLower(mention[1:])
+		if !contains(users, name) {
+			return mention
+		}
+		return fmt.Sprintf(`<a href="/%d/%s">%s</a>`, year, name, mention)
+	})
+	return template.HTML(strings.ReplaceAll(linked, "\n", "<br>"))
+}
+
+// By checks if the user wrote the comment
+func (c Comment) By(user string) bool {
+	return strings.EqualFold(string(c.Author), user)
+}
+
+// Editable checks if the user may still edit the comment
+func (c Comment) Editable(user string) bool {
+	return c.By(user) && time.Since(c.Created) < commentEditWindow
+}
+
+// Mentions returns all users from the user list that are mentioned in the comment
+func (c Comment) Mentions(users []string) []string {
+	mentioned := []string{}
+	for _, match := range mentionRegex.FindAllStringSubmatch(c.Text, -1) {
+		name := strings.ToLower(match[1])
+		for _, u := range users {
+			if u == name && !contains(mentioned, name) {
+				mentioned = append(mentioned, name)
+			}
+		}
+	}
+	return mentioned
+}
+
+// comment returns the index of the comment with the given id or -1
+func (meta Metadata) comment(id int) int {
+	for i, c := range meta.Comments {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func cleanComment(s string) string {
+	s = strings.TrimSpace(s)
+	runes := []rune(s)
+	if len(runes) > maxCommentLength {
+		runes = runes[:maxCommentLength]
+	}
+	return string(runes)
+}
+
+// comments returns the comment thread of a maimai as JSON (GET)
+// or posts a new comment of the current user (POST)
+func comments(source MaimaiSource, s *Subscriptions, users []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maimai, err := findMaimai(source, r)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if maimai == nil {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeComments(w, maimai.Meta.Comments)
+		case http.MethodPost:
+			user, _, ok := r.BasicAuth()
+			if !ok {
+				httpError(w, http.StatusUnauthorized)
+				return
+			}
+			text := cleanComment(r.FormValue("text"))
+			if len(text) == 0 {
+				httpError(w, http.StatusBadRequest)
+				return
+			}
+
+			var comment Comment
+			err := source.UpdateMetadata(maimai.CW, maimai.FileName(), func(m *Metadata) {
+				id := 1
+				for _, c := range m.Comments {
+					if c.ID >= id {
+						id = c.ID + 1
+					}
+				}
+				comment = Comment{
+					ID:      id,
+					Author:  UserName(strings.ToLower(user)),
+					Text:    text,
+					Created: time.Now(),
+				}
+				m.Comments = append(m.Comments, comment)
+			})
+			if err != nil {
+				log.Error(err)
+				httpError(w, http.StatusInternalServerError)
+				return
+			}
+
+			Events.Publish(NewMaimaiEvent(EventComment, *maimai, user))
+			go notifyComment(s, *maimai, comment, users)
+
+			if wantsJSON(r) {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(comment)
+			} else {
+				http.Redirect(w, r, maimai.Permalink()+"#comments", http.StatusSeeOther)
+			}
+		default:
+			httpError(w, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// notifyComment sends push notifications to mentioned users and the uploader
+func notifyComment(s *Subscriptions, maimai UserMaimai, comment Comment, users []string) {
+	notified := []string{string(comment.Author)}
+	for _, mentioned := range comment.Mentions(users) {
+		if contains(notified, mentioned) {
+			continue
+		}
+		s.SendTo(mentioned, fmt.Sprintf("%s hat dich in einem Kommentar erwähnt", comment.Author))
+		notified = append(notified, mentioned)
+	}
+	uploader := strings.ToLower(string(maimai.User))
+	if !contains(notified, uploader) {
+		s.SendTo(uploader, fmt.Sprintf("%s hat dein Maimai kommentiert", comment.Author))
+	}
+}
+
+// editComment changes the text of a comment (action "edit")
+// or deletes it (action "delete")
+// only the author can do this and edits are only possible within the commentEditWindow
+func editComment(source MaimaiSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, http.StatusMethodNotAllowed)
+			return
+		}
+		user, _, ok := r.BasicAuth()
+		if !ok {
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+		maimai, err := findMaimai(source, r)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		if maimai == nil || maimai.Meta.comment(id) < 0 {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		action := mux.Vars(r)["action"]
+		text := cleanComment(r.FormValue("text"))
+		if action == "edit" && len(text) == 0 {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+
+		status := http.StatusOK
+		err = source.UpdateMetadata(maimai.CW, maimai.FileName(), func(m *Metadata) {
+			i := m.comment(id)
+			if i < 0 {
+				status = http.StatusNotFound
+				return
+			}
+			c := &m.Comments[i]
+			switch {
+			case !c.By(user):
+				status = http.StatusForbidden
+			case action == "delete":
+				m.Comments = append(m.Comments[:i], m.Comments[i+1:]...)
+			case !c.Editable(user):
+				status = http.StatusForbidden
+			default:
+				c.Text = text
+				c.Edited = time.Now()
+			}
+		})
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if status != http.StatusOK {
+			httpError(w, status)
+			return
+		}
+		Events.Publish(NewMaimaiEvent(EventComment, *maimai, user))
+		http.Redirect(w, r, maimai.Permalink()+"#comments", http.StatusSeeOther)
+	}
+}
+
+func writeComments(w http.ResponseWriter, comments []Comment) {
+	if comments == nil {
+		comments = []Comment{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(comments); err != nil {
+		log.Error(err)
+	}
+}
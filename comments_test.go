@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestComments(t *testing.T) {
+	source := searchSource(t)
+	users := []string{"hans", "fritz"}
+	router := mux.NewRouter()
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}", maimaiPage(*loadTemplates("templates").Lookup("maimai.html"), source, users))
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/comments", comments(source, &Subscriptions{}, users))
+
+	form := url.Values{"text": {"Gut gemacht @Fritz und @franz"}}
+	req := httptest.NewRequest(http.MethodPost, "/2020/CW_53/1/comments", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("Hans", "")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect after posting, got %d", resp.Code)
+	}
+	meta, err := source.ReadMetadata(CW{Year: 2020, Week: 53})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comments := meta["1_hans_1.png"].Comments; len(comments) != 1 || comments[0].Author != "hans" {
+		t.Fatalf("expected a comment by hans, got %+v", comments)
+	}
+
+	page := func(user string) string {
+		req := httptest.NewRequest(http.MethodGet, "/2020/CW_53/1", nil)
+		req.SetBasicAuth(user, "")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Body.String()
+	}
+	body := page("HANS")
+	// the mention links to the year of the maimai and not the year the comment was written in
+	if !strings.Contains(body, `<a href="/2020/fritz">@Fritz</a>`) || strings.Contains(body, `/franz"`) {
+		t.Errorf("expected a link to the page of fritz in 2020 only")
+	}
+	if year := strconv.Itoa(time.Now().Year()); year != "2020" && strings.Contains(body, `href="/`+year+`/`) {
+		t.Errorf("expected no links to the pages of %s", year)
+	}
+	// the author is compared regardless of case
+	if !strings.Contains(body, "/comments/1/delete") {
+		t.Errorf("expected the author to be able to delete the comment")
+	}
+	if strings.Contains(page("fritz"), "/comments/1/delete") {
+		t.Errorf("expected others not to be able to delete the comment")
+	}
+}
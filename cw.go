@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 // CW represents a calender week and its year
@@ -14,20 +15,54 @@ type CW struct {
 	Year int
 }
 
+var cwFolderName = regexp.MustCompile(`^CW_([0-9]{2})$`)
+
 // CWFromPath parses a path of style '../YYYY/CW_WW' (YYYY = year, WW = week)
+// The week must have two digits like the folders created by CW.Path and exist in the year.
 func CWFromPath(path string) (*CW, error) {
-	kw := filepath.Base(path)
-	if matches, err := regexp.MatchString("CW_\\d{2}", kw); !matches || err != nil {
+	matches := cwFolderName.FindStringSubmatch(filepath.Base(path))
+	if matches == nil {
 		return nil, errors.New("calender week is not of expected format 'CW_WW")
 	}
-	cw, _ := strconv.Atoi(kw[3:])
+	week, _ := strconv.Atoi(matches[1])
 	yearStr := filepath.Base(filepath.Dir(path))
 	year, err := strconv.Atoi(yearStr)
 	if err != nil {
 		return nil, errors.New("year is not a digit")
 	}
-	return &CW{Year: year, Week: cw}, nil
+	cw := CW{Year: year, Week: week}
+	if !cw.Valid() {
+		return nil, fmt.Errorf("%d has no calender week %d", year, week)
+	}
+	return &cw, nil
+}
 
+// WeeksInYear returns the number of ISO calender weeks of the year, 52 or 53
+func WeeksInYear(year int) int {
+	// the 28th of december is always in the last week
+	_, week := time.Date(year, time.December, 28, 0, 0, 0, 0, time.UTC).ISOWeek()
+	return week
+}
+
+// Valid checks if the week exists in the year
+func (c CW) Valid() bool {
+	return c.Week >= 1 && c.Week <= WeeksInYear(c.Year)
+}
+
+// Next returns the following calender week, which can be in the next year
+func (c CW) Next() CW {
+	if c.Week >= WeeksInYear(c.Year) {
+		return CW{Year: c.Year + 1, Week: 1}
+	}
+	return CW{Year: c.Year, Week: c.Week + 1}
+}
+
+// Prev returns the preceding calender week, which can be in the previous year
+func (c CW) Prev() CW {
+	if c.Week <= 1 {
+		return CW{Year: c.Year - 1, Week: WeeksInYear(c.Year - 1)}
+	}
+	return CW{Year: c.Year, Week: c.Week - 1}
 }
 
 // Path returns path of CW e.g. 2020/CW_05
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWeeksInYear(t *testing.T) {
+	long := map[int]bool{2004: true, 2009: true, 2015: true, 2020: true, 2026: true, 2032: true, 2037: true}
+	for year := 2000; year <= 2040; year++ {
+		expected := 52
+		if long[year] {
+			expected = 53
+		}
+		if weeks := WeeksInYear(year); weeks != expected {
+			t.Errorf("expected %d weeks in %d, got %d", expected, year, weeks)
+		}
+	}
+}
+
+func TestNextPrev(t *testing.T) {
+	// walk day by day and compare with the iso weeks of the time package
+	day := time.Date(2000, time.January, 3, 12, 0, 0, 0, time.UTC)
+	year, week := day.ISOWeek()
+	cw := CW{Year: year, Week: week}
+	for day.Year() < 2041 {
+		day = day.AddDate(0, 0, 7)
+		year, week := day.ISOWeek()
+		next := CW{Year: year, Week: week}
+		if cw.Next() != next {
+			t.Fatalf("expected %v after %v, got %v", next, cw, cw.Next())
+		}
+		if next.Prev() != cw {
+			t.Fatalf("expected %v before %v, got %v", cw, next, next.Prev())
+		}
+		if !next.Valid() {
+			t.Fatalf("expected %v to be valid", next)
+		}
+		cw = next
+	}
+
+	for _, cw := range []CW{{Year: 2021, Week: 0}, {Year: 2021, Week: 53}, {Year: 2020, Week: 54}, {Year: 2020, Week: -1}} {
+		if cw.Valid() {
+			t.Errorf("expected %v to be invalid", cw)
+		}
+	}
+}
+
+func TestCWFromPath(t *testing.T) {
+	valid := map[string]CW{
+		"mm/2021/CW_05": {Year: 2021, Week: 5},
+		"2020/CW_53":    {Year: 2020, Week: 53},
+		"2021/CW_52":    {Year: 2021, Week: 52},
+	}
+	for path, expected := range valid {
+		cw, err := CWFromPath(path)
+		if err != nil {
+			t.Errorf("cannot parse %s: %v", path, err)
+		} else if *cw != expected {
+			t.Errorf("expected %v for %s, got %v", expected, path, *cw)
+		}
+	}
+	for _, path := range []string{"2021/CW_53", "2021/CW_00", "2021/CW_123", "2021/CW_", "2021/KW_05", "abc/CW_05", "2021/CW_05x", "2021/CW_5"} {
+		if cw, err := CWFromPath(path); err == nil {
+			t.Errorf("expected %s to be invalid, got %v", path, cw)
+		}
+	}
+}
+
+func TestNeighbourCWs(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	for _, cw := range []CW{{Year: 2020, Week: 52}, {Year: 2020, Week: 53}, {Year: 2021, Week: 1}, {Year: 2021, Week: 2}} {
+		if err := os.MkdirAll(filepath.Join(string(source), cw.Path()), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only 2020/52 and 2021/2 have maimais
+	for _, path := range []string{"2020/CW_52/1_hans_1.png", "2021/CW_02/1_fritz_1.png"} {
+		if err := os.WriteFile(filepath.Join(string(source), path), testPNG(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prev, next := source.NeighbourCWs(CW{Year: 2020, Week: 53}, false)
+	if prev == nil || *prev != (CW{Year: 2020, Week: 52}) || next == nil || *next != (CW{Year: 2021, Week: 1}) {
+		t.Errorf("expected 2020/52 and 2021/1, got %v and %v", prev, next)
+	}
+	prev, _ = source.NeighbourCWs(CW{Year: 2020, Week: 1}, false)
+	if prev != nil {
+		t.Errorf("expected no week before the first year, got %v", prev)
+	}
+	_, next = source.NeighbourCWs(Weeks.Current(), false)
+	if next != nil {
+		t.Errorf("expected no week after the current one, got %v", next)
+	}
+
+	prev, next = source.NeighbourCWs(CW{Year: 2020, Week: 52}, true)
+	if prev != nil || next == nil || *next != (CW{Year: 2021, Week: 2}) {
+		t.Errorf("expected only 2021/2 after 2020/52, got %v and %v", prev, next)
+	}
+	prev, next = source.NeighbourCWs(CW{Year: 2021, Week: 2}, true)
+	if prev == nil || *prev != (CW{Year: 2020, Week: 52}) || next != nil {
+		t.Errorf("expected only 2020/52 before 2021/2, got %v and %v", prev, next)
+	}
+}
+
+func TestWeekNavigation(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	if err := os.MkdirAll(filepath.Join(string(source), "2020", "CW_53"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	next := Weeks.Current().Next()
+	writeTestMaimais(t, source, filepath.Join(next.Path(), "template_1.png"))
+	router := mux.NewRouter()
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}", week(*loadTemplates("templates").Lookup("week.html"), source, false))
+
+	statuses := map[string]int{
+		"/2020/CW_53": http.StatusOK,
+		// linked weeks without a folder are empty
+		"/2021/CW_01": http.StatusOK,
+		"/2021/CW_53": http.StatusNotFound,
+		"/2020/CW_0":  http.StatusNotFound,
+		"/2020/CW_5":  http.StatusMovedPermanently,
+		"/2029/CW_01": http.StatusNotFound,
+		// e.g. with a template for the next week
+		"/" + filepath.ToSlash(next.Path()): http.StatusNotFound,
+	}
+	for path, status := range statuses {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != status {
+			t.Errorf("expected status %d for %s, got %d", status, path, resp.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/2020/CW_53", nil)
+	req.Header.Set("Accept", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	var data struct {
+		Prev *string
+		Next *string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Prev == nil || *data.Prev != "/2020/CW_52" || data.Next == nil || *data.Next != "/2021/CW_01" {
+		t.Errorf("expected links to /2020/CW_52 and /2021/CW_01, got %v and %v", data.Prev, data.Next)
+	}
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/2020/CW_53", nil))
+	if body := resp.Body.String(); !strings.Contains(body, `href="/2021/CW_01"`) || strings.Contains(body, "CW_54") {
+		t.Errorf("expected link to the next year in the week page")
+	}
+}
@@ -20,8 +20,11 @@ func httpError(w http.ResponseWriter, code int) {
 	case http.StatusUnauthorized:
 		w.WriteHeader(http.StatusUnauthorized)
 		fmt.Fprint(w, "ich kenn dich nicht!")
+	case http.StatusForbidden:
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "das darfst du nicht!")
 	case http.StatusBadRequest:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "so kann ich nicht arbeiten")
 	default:
 		w.WriteHeader(http.StatusInternalServerError)
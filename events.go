@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus
+const (
+	EventUpload   = "upload"
+	EventReaction = "reaction"
+	EventComment  = "comment"
+	EventCaption  = "caption"
+	EventDelete   = "delete"
+)
+
+// eventBufferSize is the number of events buffered per subscriber
+// events are dropped for subscribers that do not keep up
+const eventBufferSize = 16
+
+// Events is the global event bus all mutating handlers publish into
+var Events = NewEventBus()
+
+// Event describes a change of a maimai
+type Event struct {
+	Type    string   `json:"type"`
+	CW      CW       `json:"cw"`
+	Counter int      `json:"counter"`
+	User    UserName `json:"user"`
+}
+
+// NewMaimaiEvent creates an event of a change of the maimai by the user
+func NewMaimaiEvent(eventType string, m UserMaimai, user string) Event {
+	return Event{
+		Type:    eventType,
+		CW:      m.CW,
+		Counter: m.Counter,
+		User:    UserName(user),
+	}
+}
+
+// EventBus is a simple publish subscribe hub
+type EventBus struct {
+	lock        sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: map[chan Event]struct{}{}}
+}
+
+// Subscribe registers a new subscriber
+// the returned function must be called to unsubscribe, it closes the channel
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	b.lock.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.lock.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subscribers, ch)
+			b.lock.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends the event to all subscribers without blocking
+func (b *EventBus) Publish(e Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Warnf("dropping %s event for slow subscriber", e.Type)
+		}
+	}
+}
+
+// Subscribers returns the number of current subscribers
+func (b *EventBus) Subscribers() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subscribers)
+}
+
+// eventStream sends the events of the bus to the client as server-sent events
+func eventStream(bus *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		// disable response buffering of nginx
+		w.Header().Set("X-Accel-Buffering", "no")
+		fmt.Fprint(w, "retry: 5000\n\n")
+		flusher.Flush()
+
+		events, unsubscribe := bus.Subscribe()
+		defer unsubscribe()
+
+		// comments keep the connection open behind proxies with idle timeouts
+		keepAlive := time.NewTicker(30 * time.Second)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case e := <-events:
+				data, err := json.Marshal(e)
+				if err != nil {
+					log.Error(err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
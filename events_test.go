@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	a, unsubscribeA := bus.Subscribe()
+	b, unsubscribeB := bus.Subscribe()
+
+	bus.Publish(Event{Type: EventUpload, Counter: 1})
+	if e := <-a; e.Counter != 1 {
+		t.Errorf("expected counter 1, got %d", e.Counter)
+	}
+	if e := <-b; e.Counter != 1 {
+		t.Errorf("expected counter 1, got %d", e.Counter)
+	}
+
+	unsubscribeA()
+	unsubscribeA()
+	if n := bus.Subscribers(); n != 1 {
+		t.Errorf("expected 1 subscriber after unsubscribe, got %d", n)
+	}
+	if _, ok := <-a; ok {
+		t.Error("channel of unsubscribed client is still open")
+	}
+
+	// a subscriber that does not read must not block publishing
+	for i := 0; i < eventBufferSize*2; i++ {
+		bus.Publish(Event{Type: EventReaction, Counter: i})
+	}
+	if len(b) != eventBufferSize {
+		t.Errorf("expected %d buffered events, got %d", eventBufferSize, len(b))
+	}
+	unsubscribeB()
+	if n := bus.Subscribers(); n != 0 {
+		t.Errorf("expected no subscribers, got %d", n)
+	}
+}
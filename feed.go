@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// feedSize is the maximum number of maimais in a feed
+const feedSize = 50
+
+// latestMaimais returns the newest uploads of the given years, newest first
+// if user is not empty only the uploads of this user are returned
+func latestMaimais(source MaimaiSource, years []int, user string, limit int) ([]UserMaimai, error) {
+	sort.Sort(sort.Reverse(sort.IntSlice(years)))
+	maimais := []UserMaimai{}
+	for _, year := range years {
+		weeks, err := GetMaimais(source, year)
+		if err != nil {
+			return nil, err
+		}
+		for _, week := range weeks {
+			for _, m := range week.Maimais {
+				if len(user) == 0 || strings.EqualFold(string(m.User), user) {
+					maimais = append(maimais, m)
+				}
+			}
+		}
+		// older years can only contain older maimais
+		if len(maimais) >= limit {
+			break
+		}
+	}
+	sort.SliceStable(maimais, func(i, j int) bool {
+		return maimais[j].UploadTime.Before(maimais[i].UploadTime)
+	})
+	if len(maimais) > limit {
+		maimais = maimais[:limit]
+	}
+	return maimais, nil
+}
+
+// atomFeed is an Atom feed as specified in RFC 4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Summary string      `xml:"summary"`
+	Content atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// jsonFeed is a feed as specified on https://jsonfeed.org/version/1.1
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url"`
+	Title         string               `json:"title"`
+	ContentHTML   string               `json:"content_html"`
+	Summary       string               `json:"summary"`
+	Image         string               `json:"image"`
+	DatePublished string               `json:"date_published"`
+	Authors       []jsonFeedAuthor     `json:"authors"`
+	Attachments   []jsonFeedAttachment `json:"attachments"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type jsonFeedAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+// feedItem bundles the urls and file information of a maimai in a feed
+type feedItem struct {
+	Maimai    UserMaimai
+	Permalink string
+	ImageURL  string
+	AuthorURL string
+	MimeType  string
+	Size      int64
+}
+
+func newFeedItem(source MaimaiSource, base string, m UserMaimai) feedItem {
+	item := feedItem{
+		Maimai:    m,
+		Permalink: base + m.Permalink(),
+		ImageURL:  fmt.Sprintf("%s/mm/%s", base, m.Href()),
+		AuthorURL: fmt.Sprintf("%s/%d/%s", base, m.CW.Year, m.User),
+		MimeType:  mimeTypeForExtension(m.ImageType),
+	}
+	if info, err := os.Stat(source.FilePath(m)); err == nil {
+		item.Size = info.Size()
+	}
+	return item
+}
+
+func (f feedItem) contentHTML() string {
+	if f.Maimai.IsVideo() {
+		return fmt.Sprintf(`<p><video src="%s" controls loop title="%s"></video></p><p>%s</p>`,
+			html.EscapeString(f.ImageURL),
+			html.EscapeString(f.Maimai.Alt()),
+			html.EscapeString(f.Maimai.Title()),
+		)
+	}
+	return fmt.Sprintf(`<p><img src="%s" alt="%s"/></p><p>%s</p>`,
+		html.EscapeString(f.ImageURL),
+		html.EscapeString(f.Maimai.Alt()),
+		html.EscapeString(f.Maimai.Title()),
+	)
+}
+
+func writeAtomFeed(w http.ResponseWriter, r *http.Request, source MaimaiSource, title string, maimais []UserMaimai) {
+	base := baseURL(r)
+	updated := time.Now()
+	if len(maimais) > 0 {
+		updated = maimais[0].UploadTime
+	}
+	feed := atomFeed{
+		ID:      base + r.URL.Path,
+		Title:   title,
+		Updated: updated.In(Weeks.Location).Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: base + r.URL.Path, Type: "application/atom+xml"},
+			{Rel: "alternate", Href: base + "/", Type: "text/html"},
+		},
+		Entries: make([]atomEntry, len(maimais)),
+	}
+	for i, m := range maimais {
+		item := newFeedItem(source, base, m)
+		feed.Entries[i] = atomEntry{
+			ID:      item.Permalink,
+			Title:   m.Title(),
+			Updated: m.UploadTime.In(Weeks.Location).Format(time.RFC3339),
+			Author:  atomAuthor{Name: string(m.User), URI: item.AuthorURL},
+			Links: []atomLink{
+				{Rel: "alternate", Href: item.Permalink, Type: "text/html"},
+				{Rel: "enclosure", Href: item.ImageURL, Type: item.MimeType, Length: item.Size},
+			},
+			Summary: m.Description(),
+			Content: atomContent{Type: "html", Body: item.contentHTML()},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Error(err)
+	}
+}
+
+// atomFeedHandler serves the newest uploads of all users as Atom feed
+func atomFeedHandler(source MaimaiSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maimais, err := latestMaimais(source, source.GetYears(), "", feedSize)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		writeAtomFeed(w, r, source, "MMOTCW", maimais)
+	}
+}
+
+// userAtomFeedHandler serves the uploads of a user in a year as Atom feed
+func userAtomFeedHandler(source MaimaiSource, users []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := mux.Vars(r)["user"]
+		if !contains(users, user) {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		maimais, err := latestMaimais(source, []int{getYear(r)}, user, feedSize)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		writeAtomFeed(w, r, source, fmt.Sprintf("MMOTCW - %s", user), maimais)
+	}
+}
+
+// jsonFeedHandler serves the newest uploads of all users as JSON feed
+func jsonFeedHandler(source MaimaiSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maimais, err := latestMaimais(source, source.GetYears(), "", feedSize)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		base := baseURL(r)
+		feed := jsonFeed{
+			Version:     "https://jsonfeed.org/version/1.1",
+			Title:       "MMOTCW",
+			HomePageURL: base + "/",
+			FeedURL:     base + r.URL.Path,
+			Items:       make([]jsonFeedItem, len(maimais)),
+		}
+		for i, m := range maimais {
+			item := newFeedItem(source, base, m)
+			feed.Items[i] = jsonFeedItem{
+				ID:            item.Permalink,
+				URL:           item.Permalink,
+				Title:         m.Title(),
+				ContentHTML:   item.contentHTML(),
+				Summary:       m.Description(),
+				Image:         item.ImageURL,
+				DatePublished: m.UploadTime.In(Weeks.Location).Format(time.RFC3339),
+				Authors:       []jsonFeedAuthor{{Name: string(m.User), URL: item.AuthorURL}},
+				Attachments: []jsonFeedAttachment{
+					{URL: item.ImageURL, MimeType: item.MimeType, SizeInBytes: item.Size},
+				},
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(feed); err != nil {
+			log.Error(err)
+		}
+	}
+}
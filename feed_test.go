@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func feedSource(t *testing.T) MaimaiSource {
+	source := searchSource(t)
+	// scheduled maimais are left out of the feeds
+	writeTestMaimais(t, source, "2021/CW_14/2_fritz_1.png")
+	err := source.UpdateMetadata(CW{Year: 2021, Week: 14}, "2_fritz_1.png", func(m *Metadata) {
+		m.PublishAt = time.Now().Add(time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func TestAtomFeed(t *testing.T) {
+	source := feedSource(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/feed.atom", atomFeedHandler(source))
+	router.HandleFunc("/{year:202[0-9]}/{user:[a-z]+}/feed.atom", userAtomFeedHandler(source, []string{"hans", "fritz", "franz"}))
+
+	tests := []struct {
+		url      string
+		expected []string
+	}{
+		{"/feed.atom", []string{"/2021/CW_14/1", "/2021/CW_12/1", "/2020/CW_53/1"}},
+		{"/2021/hans/feed.atom", []string{"/2021/CW_14/1"}},
+		{"/2021/franz/feed.atom", []string{}},
+	}
+	for _, test := range tests {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+			t.Fatalf("expected an atom feed for %s, got %d", test.url, resp.Code)
+		}
+		var feed atomFeed
+		if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+			t.Fatalf("invalid xml for %s: %v", test.url, err)
+		}
+		if feed.Links[0].Href != "http://example.com"+test.url {
+			t.Errorf("expected a self link to %s, got %s", test.url, feed.Links[0].Href)
+		}
+		if len(feed.Entries) != len(test.expected) {
+			t.Errorf("expected %d entries for %s, got %d", len(test.expected), test.url, len(feed.Entries))
+			continue
+		}
+		for i, entry := range feed.Entries {
+			if entry.ID != "http://example.com"+test.expected[i] {
+				t.Errorf("expected %s at %d in %s, got %s", test.expected[i], i, test.url, entry.ID)
+			}
+			if _, err := time.Parse(time.RFC3339, entry.Updated); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	// the newest entry
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/feed.atom", nil))
+	var feed atomFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		t.Fatal(err)
+	}
+	enclosure := feed.Entries[0].Links[1]
+	if enclosure.Href != "http://example.com/mm/2021/CW_14/1_hans_1.png" || enclosure.Type != "image/png" || enclosure.Length == 0 {
+		t.Errorf("unexpected enclosure %+v", enclosure)
+	}
+	if feed.Entries[0].Title != "Ostern" || feed.Updated != feed.Entries[0].Updated {
+		t.Errorf("unexpected title %s or update time %s", feed.Entries[0].Title, feed.Updated)
+	}
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/2021/nobody/feed.atom", nil))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown user, got %d", resp.Code)
+	}
+}
+
+func TestJSONFeed(t *testing.T) {
+	source := feedSource(t)
+	resp := httptest.NewRecorder()
+	jsonFeedHandler(source)(resp, httptest.NewRequest(http.MethodGet, "/feed.json", nil))
+
+	var feed jsonFeed
+	if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Version != "https://jsonfeed.org/version/1.1" || feed.FeedURL != "http://example.com/feed.json" {
+		t.Errorf("unexpected feed %+v", feed)
+	}
+	expected := []string{"/2021/CW_14/1", "/2021/CW_12/1", "/2020/CW_53/1"}
+	if len(feed.Items) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(feed.Items))
+	}
+	for i, item := range feed.Items {
+		if item.URL != "http://example.com"+expected[i] {
+			t.Errorf("expected %s at %d, got %s", expected[i], i, item.URL)
+		}
+	}
+	if item := feed.Items[1]; item.Authors[0].URL != "http://example.com/2021/fritz" || item.Attachments[0].MimeType != "image/png" {
+		t.Errorf("unexpected author or attachment %+v", item)
+	}
+}
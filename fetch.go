@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"syscall"
+	"time"
+)
+
+// errFileTooLarge is returned when the file is larger than the size limit of the fetcher
+var errFileTooLarge = errors.New("file is too large")
+
+// errPrivateAddress is returned when a url resolves to an address that must not be fetched
+var errPrivateAddress = errors.New("private and loopback addresses are not allowed")
+
+// reservedNets are the special purpose address ranges that are not reachable from the internet
+// or reach the local machine and are not covered by the methods of net.IP
+var reservedNets = parseCIDRs(
+	"0.0.0.0/8",       // this network, 0.0.0.0 reaches localhost on Linux, RFC 1122
+	"100.64.0.0/10",   // carrier-grade NAT, RFC 6598
+	"192.0.0.0/24",    // IETF protocol assignments, RFC 6890
+	"192.0.2.0/24",    // documentation, RFC 5737
+	"198.18.0.0/15",   // benchmarking, RFC 2544
+	"198.51.100.0/24", // documentation, RFC 5737
+	"203.0.113.0/24",  // documentation, RFC 5737
+	"240.0.0.0/4",     // reserved and broadcast, RFC 1112
+	"64:ff9b::/96",    // NAT64 to IPv4 addresses, RFC 6052
+	"64:ff9b:1::/48",  // local NAT64, RFC 8215
+	"100::/64",        // discard, RFC 6666
+	"2001::/32",       // Teredo tunnels to IPv4 addresses, RFC 4380
+	"2001:db8::/32",   // documentation, RFC 3849
+	"2002::/16",       // 6to4 tunnels to IPv4 addresses, RFC 3056
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// URLFetcher downloads files from the internet for uploads by url
+type URLFetcher struct {
+	client  *http.Client
+	maxSize int64
+}
+
+// NewURLFetcher creates a fetcher for files up to maxSize bytes, 0 means unlimited
+// Unless allowPrivate is set, connections to private, loopback and link-local
+// addresses are refused. The check happens when connecting, so it also
+// applies to redirects and hosts that resolve to different addresses over time.
+func NewURLFetcher(maxSize int64, timeout time.Duration, allowPrivate bool) *URLFetcher {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if isPrivateIP(net.ParseIP(host)) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &URLFetcher{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				// no proxy, it would bypass the address check
+				Proxy:                 nil,
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   timeout,
+				ResponseHeaderTimeout: timeout,
+			},
+		},
+		maxSize: maxSize,
+	}
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip == nil ||
+		ip.IsPrivate() ||
+		ip.IsLoopback() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return true
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch downloads the file behind rawURL
+// returns the content and a file name derived from the url
+func (f *URLFetcher) Fetch(ctx context.Context, rawURL string) (*bytes.Reader, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", fmt.Errorf("unsupported url scheme '%s'", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("server responded with status %d", resp.StatusCode)
+	}
+	if f.maxSize > 0 && resp.ContentLength > f.maxSize {
+		return nil, "", errFileTooLarge
+	}
+	var body io.Reader = resp.Body
+	if f.maxSize > 0 {
+		body = io.LimitReader(resp.Body, f.maxSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", err
+	}
+	if f.maxSize > 0 && int64(len(data)) > f.maxSize {
+		return nil, "", errFileTooLarge
+	}
+	return bytes.NewReader(data), path.Base(resp.Request.URL.Path), nil
+}
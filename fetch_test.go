@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPNG(t *testing.T) []byte {
+	buffer := bytes.NewBuffer(nil)
+	if err := png.Encode(buffer, image.NewRGBA(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestFetchRejectsPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testPNG(t))
+	}))
+	defer server.Close()
+
+	fetcher := NewURLFetcher(1<<20, time.Second, false)
+	_, _, err := fetcher.Fetch(context.Background(), server.URL+"/meme.png")
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("expected loopback address to be rejected, got %v", err)
+	}
+
+	for _, u := range []string{"file:///etc/passwd", "ftp://example.com/meme.png"} {
+		if _, _, err := fetcher.Fetch(context.Background(), u); err == nil {
+			t.Errorf("expected %s to be rejected", u)
+		}
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"10.1.2.3", true},
+		{"100.64.0.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"172.16.0.1", true},
+		{"192.0.0.8", true},
+		{"192.168.178.1", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"64:ff9b::7f00:1", true},
+		{"2002:7f00:1::", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"1.1.1.1", false},
+		{"100.128.0.1", false},
+		{"198.20.0.1", false},
+		{"2a00:1450:4001:82b::200e", false},
+	}
+	for _, test := range tests {
+		if private := isPrivateIP(net.ParseIP(test.ip)); private != test.private {
+			t.Errorf("expected %s to be private: %v", test.ip, test.private)
+		}
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no content length header for chunked responses
+		w.(http.Flusher).Flush()
+		w.Write(make([]byte, 2048))
+	}))
+	defer server.Close()
+
+	fetcher := NewURLFetcher(1024, time.Second, true)
+	if _, _, err := fetcher.Fetch(context.Background(), server.URL); err == nil {
+		t.Error("expected file larger than limit to be rejected")
+	}
+}
+
+func TestUploadByURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/meme.png":
+			// wrong content type, the file content decides
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(testPNG(t))
+		case "/text.txt":
+			w.Write([]byte(strings.Repeat("kein bild ", 100)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := MaimaiSource(t.TempDir())
+	handler := uploadHandler(source, &Subscriptions{}, NewURLFetcher(1<<20, time.Second, true), DefaultUploadOptions)
+
+	form := url.Values{"url": {server.URL + "/meme.png", server.URL + "/text.txt", server.URL + "/missing.png"}}
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("hans", "")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	results := []UploadResult{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if len(results[0].Error) > 0 || len(results[0].Maimai) == 0 {
+		t.Errorf("expected png to be stored, got %+v", results[0])
+	}
+	if len(results[1].Error) == 0 || len(results[2].Error) == 0 {
+		t.Errorf("expected text file and missing file to fail, got %+v", results[1:])
+	}
+
+	year, week := time.Now().ISOWeek()
+	cw := CW{Year: year, Week: week}
+	if _, err := os.Stat(filepath.Join(string(source), cw.Path(), results[0].Maimai)); err != nil {
+		t.Error(err)
+	}
+	meta, err := source.ReadMetadata(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := meta[results[0].Maimai]; m.SourceURL != server.URL+"/meme.png" || m.Width != 20 {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+}
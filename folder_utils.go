@@ -1,7 +1,7 @@
 package main
 
 import (
-	"mime/multipart"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -22,7 +22,7 @@ func checkCWFolder(cw CW, path string) (string, error) {
 
 }
 
-func detectType(f multipart.File) (string, error) {
+func detectType(f io.ReadSeeker) (string, error) {
 	buffer := make([]byte, 512)
 	_, err := f.Read(buffer)
 	if err != nil {
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// oswaldTTF is Oswald, a condensed display font like Impact under the SIL Open Font License
+// see static/fonts/Oswald-OFL.txt
+//
+//go:embed static/fonts/Oswald-Regular.ttf
+var oswaldTTF []byte
+
+// maxTextBoxes limits the number of text boxes of a generated maimai
+const maxTextBoxes = 10
+
+// minFontSize is the smallest font size used when text is shrunk to fit its box
+const minFontSize = 8
+
+// maxFontSize is the largest font size that can be requested for a text box
+const maxFontSize = 400
+
+// maxOutline is the widest outline in pixels, the outline is drawn once per pixel of its area
+const maxOutline = 12
+
+// memeFonts are the fonts that can be used in text boxes
+var memeFonts = map[string]*lazyFont{
+	// the key stays "impact" for existing generator requests
+	"impact": {data: oswaldTTF},
+	"bold":   {data: gobold.TTF},
+	"mono":   {data: gomonobold.TTF},
+}
+
+// lazyFont parses a font the first time it is used
+type lazyFont struct {
+	data []byte
+	once sync.Once
+	font *opentype.Font
+	err  error
+}
+
+func (f *lazyFont) get() (*opentype.Font, error) {
+	f.once.Do(func() {
+		f.font, f.err = opentype.Parse(f.data)
+	})
+	return f.font, f.err
+}
+
+// TextBox is a text that is rendered on top of a template
+// Position and size are relative to the template size (0 to 1).
+type TextBox struct {
+	Text   string  `json:"text"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	// Font is one of the keys of memeFonts, defaults to "impact"
+	Font string `json:"font,omitempty"`
+	// Size is the font size in pixels of the template, 0 fits the text into the box
+	// otherwise it is between minFontSize and maxFontSize
+	Size float64 `json:"size,omitempty"`
+	// Color and OutlineColor are hex colors like #ffffff
+	Color        string `json:"color,omitempty"`
+	OutlineColor string `json:"outlineColor,omitempty"`
+	// Outline is the width of the outline in pixels, at most maxOutline
+	Outline int `json:"outline,omitempty"`
+}
+
+// validate checks the font size and outline of the box, the error is shown to the user
+func (b TextBox) validate() error {
+	if b.Size != 0 && !(b.Size >= minFontSize && b.Size <= maxFontSize) {
+		return fmt.Errorf("Die Schriftgröße muss zwischen %d und %d liegen", minFontSize, maxFontSize)
+	}
+	if b.Outline < 0 || b.Outline > maxOutline {
+		return fmt.Errorf("Der Rand darf höchstens %d Pixel breit sein", maxOutline)
+	}
+	return nil
+}
+
+// GeneratorRequest is the body of a request to the maimai generator
+type GeneratorRequest struct {
+	// Template is the file name of the template, defaults to the first template of the week
+	Template string    `json:"template,omitempty"`
+	Boxes    []TextBox `json:"boxes"`
+	Caption  string    `json:"caption,omitempty"`
+	Alt      string    `json:"alt,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	// Preview returns the rendered image instead of uploading it
+	Preview bool `json:"preview,omitempty"`
+}
+
+// parseHexColor parses colors like #fff or #ffffff
+func parseHexColor(s string, fallback color.Color) (color.Color, error) {
+	if len(s) == 0 {
+		return fallback, nil
+	}
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 6 {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// wrapText breaks the text into lines that are not wider than width
+// Words longer than a line are not broken.
+func wrapText(face font.Face, text string, width fixed.Int26_6) []string {
+	lines := []string{}
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if len(line) > 0 {
+				candidate = line + " " + word
+			}
+			if len(line) > 0 && font.MeasureString(face, candidate) > width {
+				lines = append(lines, line)
+				line = word
+			} else {
+				line = candidate
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// layoutText wraps the text of the box and returns the lines with the face they fit into the box with
+// if size is 0 the largest font size is chosen that fits
+func layoutText(f *opentype.Font, text string, size float64, box image.Rectangle) (font.Face, []string, error) {
+	if size <= 0 {
+		size = float64(box.Dy())
+	}
+	for {
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, nil, err
+		}
+		lines := wrapText(face, text, fixed.I(box.Dx()))
+		widest := fixed.Int26_6(0)
+		for _, line := range lines {
+			if w := font.MeasureString(face, line); w > widest {
+				widest = w
+			}
+		}
+		height := face.Metrics().Height.Mul(fixed.I(len(lines)))
+		if size <= minFontSize || (widest <= fixed.I(box.Dx()) && height <= fixed.I(box.Dy())) {
+			return face, lines, nil
+		}
+		face.Close()
+		size *= 0.9
+	}
+}
+
+// drawTextBox renders the text box centered in its area with an outline
+func drawTextBox(dst draw.Image, box TextBox) error {
+	bounds := dst.Bounds()
+	area := image.Rect(
+		bounds.Min.X+int(box.X*float64(bounds.Dx())),
+		bounds.Min.Y+int(box.Y*float64(bounds.Dy())),
+		bounds.Min.X+int((box.X+box.Width)*float64(bounds.Dx())),
+		bounds.Min.Y+int((box.Y+box.Height)*float64(bounds.Dy())),
+	).Intersect(bounds)
+	if area.Empty() {
+		return fmt.Errorf("text box %q is outside of the template", box.Text)
+	}
+
+	fontName := box.Font
+	if len(fontName) == 0 {
+		fontName = "impact"
+	}
+	lf, ok := memeFonts[fontName]
+	if !ok {
+		return fmt.Errorf("unknown font %q", box.Font)
+	}
+	f, err := lf.get()
+	if err != nil {
+		return err
+	}
+	fill, err := parseHexColor(box.Color, color.White)
+	if err != nil {
+		return err
+	}
+	outline, err := parseHexColor(box.OutlineColor, color.Black)
+	if err != nil {
+		return err
+	}
+
+	face, lines, err := layoutText(f, box.Text, box.Size, area)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	lineHeight := metrics.Height
+	textHeight := lineHeight.Mul(fixed.I(len(lines)))
+	y := fixed.I(area.Min.Y) + (fixed.I(area.Dy())-textHeight)/2 + metrics.Ascent
+	drawer := font.Drawer{Dst: dst, Face: face}
+	for _, line := range lines {
+		x := fixed.I(area.Min.X) + (fixed.I(area.Dx())-font.MeasureString(face, line))/2
+		if box.Outline > 0 {
+			drawer.Src = image.NewUniform(outline)
+			for dy := -box.Outline; dy <= box.Outline; dy++ {
+				for dx := -box.Outline; dx <= box.Outline; dx++ {
+					if dx*dx+dy*dy > box.Outline*box.Outline {
+						continue
+					}
+					drawer.Dot = fixed.Point26_6{X: x + fixed.I(dx), Y: y + fixed.I(dy)}
+					drawer.DrawString(line)
+				}
+			}
+		}
+		drawer.Src = image.NewUniform(fill)
+		drawer.Dot = fixed.Point26_6{X: x, Y: y}
+		drawer.DrawString(line)
+		y += lineHeight
+	}
+	return nil
+}
+
+// RenderMaimai draws the text boxes on the template image
+func RenderMaimai(template image.Image, boxes []TextBox) (*image.RGBA, error) {
+	b := template.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), template, b.Min, draw.Src)
+	for _, box := range boxes {
+		if len(strings.TrimSpace(box.Text)) == 0 {
+			continue
+		}
+		if err := drawTextBox(img, box); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// generatorHandler renders text boxes on the template of the current week
+// and uploads the result like a regular upload
+func generatorHandler(source MaimaiSource, s *Subscriptions, options UploadOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, http.StatusMethodNotAllowed)
+			return
+		}
+		user, _, ok := r.BasicAuth()
+		if !ok {
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+
+		request := GeneratorRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+			log.Warn(err)
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		if len(request.Boxes) == 0 || len(request.Boxes) > maxTextBoxes {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Zwischen 1 und %d Textboxen bitte", maxTextBoxes)
+			return
+		}
+		for _, box := range request.Boxes {
+			if err := box.validate(); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, err.Error())
+				return
+			}
+		}
+
+		cw := Weeks.Current()
+		weekData, err := source.GetMaimaisForCW(cw)
+		if err != nil && !os.IsNotExist(err) {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if weekData == nil || len(weekData.Templates) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Diese Woche gibt es noch kein Template")
+			return
+		}
+		template := weekData.Templates[0]
+		if len(request.Template) > 0 {
+			t := weekData.Template(request.Template)
+			if t == nil {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "Das Template gibt es nicht")
+				return
+			}
+			template = *t
+		}
+		background, err := decodeFrame(source.FilePath(template))
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		img, err := RenderMaimai(background, request.Boxes)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		contentType := "image/png"
+		if template.ImageType == "jpg" {
+			contentType = "image/jpeg"
+			err = jpeg.Encode(buffer, img, &jpeg.Options{Quality: 92})
+		} else {
+			err = png.Encode(buffer, img)
+		}
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		if request.Preview {
+			w.Header().Set("Content-Type", contentType)
+			w.Write(buffer.Bytes())
+			return
+		}
+		saveUploads(w, r, source, s, options, user, cw, time.Time{}, []upload{{
+			file:     bytes.NewReader(buffer.Bytes()),
+			fileName: template.FileName(),
+			caption:  cleanCaption(request.Caption),
+			alt:      cleanCaption(request.Alt),
+			tags:     parseTags(strings.Join(request.Tags, ",")),
+			template: template.FileName(),
+		}})
+	}
+}
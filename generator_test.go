@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenderMaimai(t *testing.T) {
+	background := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	img, err := RenderMaimai(background, []TextBox{
+		{Text: "OBEN", X: 0, Y: 0, Width: 1, Height: 0.5, Outline: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	white, black := 0, 0
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.R == 255 && c.G == 255 && c.B == 255 {
+				white++
+				if y >= 50 {
+					t.Fatalf("expected text to stay in the upper half, found white pixel at %d,%d", x, y)
+				}
+			} else if c.A == 255 && c.R == 0 {
+				black++
+			}
+		}
+	}
+	if white == 0 || black == 0 {
+		t.Errorf("expected white text with black outline, got %d white and %d black pixels", white, black)
+	}
+
+	if _, err := RenderMaimai(background, []TextBox{{Text: "x", Width: 1, Height: 1, Font: "comic sans"}}); err == nil {
+		t.Error("expected unknown font to be rejected")
+	}
+	if _, err := RenderMaimai(background, []TextBox{{Text: "x", X: 2, Y: 2, Width: 1, Height: 1}}); err == nil {
+		t.Error("expected box outside of the template to be rejected")
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	handler := generatorHandler(source, &Subscriptions{}, DefaultUploadOptions)
+	body, _ := json.Marshal(GeneratorRequest{
+		Boxes:   []TextBox{{Text: "Wenn der Code kompiliert", Width: 1, Height: 0.3}},
+		Caption: "generiert",
+	})
+	request := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/generator", bytes.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth("hans", "")
+		return req
+	}
+
+	resp := httptest.NewRecorder()
+	handler(resp, request())
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without template, got %d", resp.Code)
+	}
+
+	for _, box := range []TextBox{{Text: "x", Width: 1, Height: 1, Outline: maxOutline + 1}, {Text: "x", Width: 1, Height: 1, Outline: -1}, {Text: "x", Width: 1, Height: 1, Size: maxFontSize * 10}, {Text: "x", Width: 1, Height: 1, Size: 1}} {
+		invalid, _ := json.Marshal(GeneratorRequest{Boxes: []TextBox{box}})
+		req := httptest.NewRequest(http.MethodPost, "/generator", bytes.NewReader(invalid))
+		req.SetBasicAuth("hans", "")
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for outline %d and size %v, got %d", box.Outline, box.Size, resp.Code)
+		}
+	}
+
+	cw := templateWeek(time.Now(), "current")
+	if _, err := source.StoreTemplate(cw, testPNG(t), "png", "", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	resp = httptest.NewRecorder()
+	handler(resp, request())
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	results := []UploadResult{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Maimai) == 0 {
+		t.Fatalf("expected generated maimai to be stored, got %+v", results)
+	}
+	week, err := source.GetMaimaisForCW(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(week.Maimais) != 1 || week.Maimais[0].User != "hans" || week.Maimais[0].Meta.Caption != "generiert" || week.Maimais[0].Meta.Template != "template_1.png" {
+		t.Errorf("unexpected maimais %+v", week.Maimais)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"image"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -32,11 +34,14 @@ type UserMaimai struct {
 	// number for users upload
 	UserCounter int
 
-	// image type e.g. jpg, jpeg, png, gif
+	// image type e.g. jpg, jpeg, png, gif, webp, mp4, webm
 	ImageType string
 
 	// calender week the maimai belongs to
 	CW CW
+
+	// additional information stored in the week's sidecar file
+	Meta Metadata
 }
 
 // NewUserMaimai creates a Maimai object from a filename
@@ -83,26 +88,140 @@ func (m UserMaimai) Href() string {
 	return filepath.Join(m.CW.Path(), m.FileName())
 }
 
+// Permalink returns the url of the maimai's detail page
+// e.g. /2021/CW_05/12
+func (m UserMaimai) Permalink() string {
+	return fmt.Sprintf("/%s/%d", m.CW.Path(), m.Counter)
+}
+
 // FileName returns the maimais filename
 // e.g. 12_hans_1.gif
 func (m UserMaimai) FileName() string {
 	return fmt.Sprintf("%d_%s_%d.%s", m.Counter, m.User, m.UserCounter, m.ImageType)
 }
 
+// IsVideo checks if the maimai is a video
+func (m UserMaimai) IsVideo() bool {
+	return isVideoExtension(m.ImageType)
+}
+
+// PosterHref returns the relative url of the poster frame of a video
+// e.g. 2021/CW_05/posters/12_hans_1.jpg
+func (m UserMaimai) PosterHref() string {
+	return filepath.Join(m.CW.Path(), posterFolder, strings.TrimSuffix(m.FileName(), "."+m.ImageType)+".jpg")
+}
+
+// By checks if the user uploaded the maimai, user names are compared case-insensitively
+func (m UserMaimai) By(user string) bool {
+	return strings.EqualFold(string(m.User), user)
+}
+
+// Title returns the caption or the file name if there is none
+func (m UserMaimai) Title() string {
+	if len(m.Meta.Caption) > 0 {
+		return m.Meta.Caption
+	}
+	return m.FileName()
+}
+
+// Alt returns the text alternative for the image
+// falls back to the recognized text, the caption and then to a generic description
+func (m UserMaimai) Alt() string {
+	if len(m.Meta.AltText) > 0 {
+		return m.Meta.AltText
+	}
+	if len(m.Meta.OCRText) > 0 {
+		return m.Meta.OCRText
+	}
+	if len(m.Meta.Caption) > 0 {
+		return m.Meta.Caption
+	}
+	return fmt.Sprintf("Maimai von %s", m.User)
+}
+
+// Description returns a short text about the maimai for link previews and feeds
+// e.g. Maimai von hans aus CW 5 2021
+func (m UserMaimai) Description() string {
+	desc := fmt.Sprintf("Maimai von %s aus CW %d %d", m.User, m.CW.Week, m.CW.Year)
+	if len(m.Meta.AltText) > 0 {
+		desc += ": " + m.Meta.AltText
+	}
+	return desc
+}
+
 // Preview returns the preview cached image
+// the poster frame is used for videos
 func (m UserMaimai) Preview() (CachedImage, error) {
+	if m.IsVideo() {
+		if err := MaimaiSource(ImgCache.dir).EnsurePoster(m); err != nil {
+			return CachedImage{Image: ""}, err
+		}
+		return ImgCache.GetImage(m.PosterHref())
+	}
 	return ImgCache.GetImage(m.Href())
 }
 
+// Placeholder returns the preview image or an empty image with the size of the maimai
+// if there is no preview, e.g. for videos without poster frame
+func (m UserMaimai) Placeholder() CachedImage {
+	preview, err := m.Preview()
+	if err == nil {
+		return preview
+	}
+	log.Debugf("no preview for %s: %v", m.Href(), err)
+	placeholder := CachedImage{Size: image.Point{330, 330}}
+	if m.Meta.Width > 0 {
+		placeholder.Size.Y = m.Meta.Height * placeholder.Size.X / m.Meta.Width
+	}
+	return placeholder
+}
+
+// TemplateHref returns the url of the template the maimai is based on
+// returns an empty string if it is not based on a template
+func (m UserMaimai) TemplateHref() string {
+	if len(m.Meta.Template) == 0 {
+		return ""
+	}
+	return "/mm/" + filepath.ToSlash(filepath.Join(m.CW.Path(), m.Meta.Template))
+}
+
 // Before returns true if counter is smaller than the one it is compared to
 func (m UserMaimai) Before(a UserMaimai) bool {
 	return m.Counter < a.Counter
 }
 
+// templateFileName matches template.png (number 0) and template_2.png
+var templateFileName = regexp.MustCompile(`^template(?:_([0-9]+))?\.([a-z0-9]+)$`)
+
 // Template image for a week
 type Template struct {
 	CW        CW
 	ImageType string
+
+	// Number distinguishes the templates of a week, 0 is the old single template.* file
+	Number int
+
+	// Meta holds the title of the template as caption
+	Meta Metadata
+}
+
+// NewTemplate creates a template from its file name
+// e.g. template.png or template_2.jpg
+func NewTemplate(fileName string, cw CW) (*Template, error) {
+	matches := templateFileName.FindStringSubmatch(fileName)
+	if matches == nil {
+		return nil, fmt.Errorf("%s is not of expected file name format for a template", fileName)
+	}
+	number := 0
+	if len(matches[1]) > 0 {
+		number, _ = strconv.Atoi(matches[1])
+	}
+	return &Template{CW: cw, ImageType: matches[2], Number: number}, nil
+}
+
+// isTemplateFile checks if the file name belongs to a template
+func isTemplateFile(fileName string) bool {
+	return templateFileName.MatchString(fileName)
 }
 
 // Href returns the relative url for the maimai
@@ -110,13 +229,41 @@ func (m Template) Href() string {
 	return filepath.Join(m.CW.Path(), m.FileName())
 }
 
-// FileName returns the maimais filename
-// e.g. 12_hans_1.gif
+// FileName returns the templates filename
+// e.g. template_2.png
 func (m Template) FileName() string {
-	return fmt.Sprintf("template.%s", m.ImageType)
+	if m.Number == 0 {
+		return fmt.Sprintf("template.%s", m.ImageType)
+	}
+	return fmt.Sprintf("template_%d.%s", m.Number, m.ImageType)
+}
+
+// Title returns the title of the template or a generic one
+func (m Template) Title() string {
+	if len(m.Meta.Caption) > 0 {
+		return m.Meta.Caption
+	}
+	if m.Number == 0 {
+		return "Template"
+	}
+	return fmt.Sprintf("Template %d", m.Number)
+}
+
+// Alt returns the recognized text of the template or its title
+func (m Template) Alt() string {
+	if len(m.Meta.OCRText) > 0 {
+		return m.Meta.OCRText
+	}
+	return m.Title()
 }
 
 // Preview returns the preview cached image
 func (m Template) Preview() (CachedImage, error) {
 	return ImgCache.GetImage(m.Href())
 }
+
+// Card is a maimai as shown to the current user by the card template
+type Card struct {
+	Maimai UserMaimai
+	User   string
+}
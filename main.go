@@ -12,6 +12,7 @@ import (
 	_ "image/png"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -21,6 +22,7 @@ import (
 
 	"github.com/gorilla/mux"
 	logger "github.com/withmandala/go-log"
+	_ "golang.org/x/image/webp"
 )
 
 var log *logger.Logger
@@ -30,22 +32,26 @@ func init() {
 }
 
 // GetMaimais returns all maiamis for a given year structured in weeks
+// Weeks without maimais are only returned if they have templates.
+// Weeks after the current one, e.g. with templates for the next week, are left out.
 func GetMaimais(source MaimaiSource, year int) ([]Week, error) {
 	weekFolders, err := filepath.Glob(filepath.Join(string(source), strconv.Itoa(year), "CW_*"))
 	if err != nil {
 		return nil, err
 	}
-	weeks := make([]Week, len(weekFolders))
-	for i, w := range weekFolders {
+	current := Weeks.Current()
+	weeks := make([]Week, 0, len(weekFolders))
+	for _, w := range weekFolders {
 		week, err := ReadWeek(w)
 		if err != nil {
 			return nil, err
 		}
-		if len(week.Maimais) == 0 {
-			// if week folder is empty we remove it
-			weeks = append(weeks[:i], weeks[i+1:]...)
-		} else {
-			weeks[i] = *week
+		if current.Before(week.CW) {
+			continue
+		}
+		// empty week folders are skipped
+		if len(week.Maimais) > 0 || len(week.Templates) > 0 {
+			weeks = append(weeks, *week)
 		}
 	}
 	// sort weeks
@@ -55,7 +61,7 @@ func GetMaimais(source MaimaiSource, year int) ([]Week, error) {
 	return weeks, nil
 }
 
-func index(template template.Template, source MaimaiSource, s *Subscriptions, users []string) http.HandlerFunc {
+func index(template template.Template, source MaimaiSource, s *Subscriptions, users []string, templateMasters []string) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, _, _ := r.BasicAuth()
@@ -73,6 +79,26 @@ func index(template template.Template, source MaimaiSource, s *Subscriptions, us
 
 		years := source.GetYears()
 
+		templates := []Template{}
+		replaceable := map[string][]Template{}
+		for _, week := range []string{"current", "next"} {
+			data, err := source.GetMaimaisForCW(templateWeek(time.Now(), week))
+			if err != nil {
+				continue
+			}
+			if week == "current" {
+				templates = data.Templates
+			}
+			replaceable[week] = append(append([]Template{}, data.Templates...), data.ScheduledTemplates...)
+		}
+
+		var thumbnail Maimai
+		for _, week := range maimais {
+			if thumbnail = week.Thumbnail(); thumbnail != nil {
+				break
+			}
+		}
+
 		err = template.Execute(w, struct {
 			Weeks         []Week
 			User          string
@@ -80,6 +106,17 @@ func index(template template.Template, source MaimaiSource, s *Subscriptions, us
 			Year          int
 			Users         []string
 			Years         []int
+			Social        SocialMeta
+			// CanUploadTemplate shows the template upload form
+			CanUploadTemplate bool
+			// Templates are the templates of the current week
+			Templates []Template
+			// ReplaceableTemplates are the templates of the current and the next week
+			// including scheduled ones, keyed by the value of the week field of the template form
+			ReplaceableTemplates map[string][]Template
+			// GraceWeek is the previous week if uploads can still go into it
+			GraceWeek *CW
+			CW        CW
 		}{
 			Weeks:         maimais,
 			User:          user,
@@ -87,6 +124,16 @@ func index(template template.Template, source MaimaiSource, s *Subscriptions, us
 			Year:          year,
 			Users:         users,
 			Years:         years,
+			Social: newSocialMeta(r,
+				fmt.Sprintf("MMOTCW %d", year),
+				"Maimai of the corona week",
+				thumbnail,
+			),
+			CanUploadTemplate:    canUploadTemplate(user, templateMasters),
+			Templates:            templates,
+			ReplaceableTemplates: replaceable,
+			GraceWeek:            Weeks.GraceWeek(time.Now()),
+			CW:                   Weeks.Current(),
 		})
 		if err != nil {
 			log.Error(err)
@@ -128,14 +175,31 @@ func userContent(template template.Template, source MaimaiSource, users []string
 
 		years := source.GetYears()
 
+		var thumbnail Maimai
+		count := 0
+		for _, week := range weeks {
+			if len(week.Maimais) > 0 && thumbnail == nil {
+				thumbnail = week.Maimais[0]
+			}
+			count += len(week.Maimais)
+		}
+
 		err = template.Execute(w, struct {
-			Weeks []Week
-			User  string
-			Years []int
+			Weeks  []Week
+			User   string
+			Year   int
+			Years  []int
+			Social SocialMeta
 		}{
 			Weeks: weeks,
 			User:  user,
+			Year:  year,
 			Years: years,
+			Social: newSocialMeta(r,
+				fmt.Sprintf("Maimais von %s %d", user, year),
+				fmt.Sprintf("%d Maimais von %s im Jahr %d", count, user, year),
+				thumbnail,
+			),
 		})
 		if err != nil {
 			log.Error(err)
@@ -144,12 +208,29 @@ func userContent(template template.Template, source MaimaiSource, users []string
 	}
 }
 
-func week(template template.Template, source MaimaiSource) http.HandlerFunc {
+// week shows all maimais of a calender week with links to the neighbouring weeks
+// If skipEmpty is set, the links skip weeks without maimais.
+func week(template template.Template, source MaimaiSource, skipEmpty bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		week, _ := strconv.Atoi(mux.Vars(r)["week"])
 		year, _ := strconv.Atoi(mux.Vars(r)["year"])
+		cw := CW{Year: year, Week: week}
+		// weeks after the current one are not shown yet
+		if !cw.Valid() || Weeks.Current().Before(cw) {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		// week folders always have two digits
+		if mux.Vars(r)["week"] != fmt.Sprintf("%02d", week) {
+			http.Redirect(w, r, "/"+filepath.ToSlash(cw.Path()), http.StatusMovedPermanently)
+			return
+		}
 
-		maimais, err := source.GetMaimaisForCW(CW{Year: year, Week: week})
+		maimais, err := source.GetMaimaisForCW(cw)
+		if os.IsNotExist(err) && source.IsNavigable(cw) {
+			// weeks without uploads are linked as well, so they are shown empty
+			maimais, err = &Week{CW: cw, Maimais: []UserMaimai{}}, nil
+		}
 		if err != nil {
 			switch err.(type) {
 			case *os.PathError:
@@ -160,13 +241,99 @@ func week(template template.Template, source MaimaiSource) http.HandlerFunc {
 			}
 			return
 		}
+		prev, next := source.NeighbourCWs(cw, skipEmpty)
+
+		if wantsJSON(r) {
+			link := func(c *CW) *string {
+				if c == nil {
+					return nil
+				}
+				path := "/" + filepath.ToSlash(c.Path())
+				return &path
+			}
+			permalinks := make([]string, len(maimais.Maimais))
+			for i, mm := range maimais.Maimais {
+				permalinks[i] = mm.Permalink()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(struct {
+				Year    int      `json:"year"`
+				Week    int      `json:"week"`
+				Prev    *string  `json:"prev"`
+				Next    *string  `json:"next"`
+				Maimais []string `json:"maimais"`
+			}{
+				Year:    year,
+				Week:    week,
+				Prev:    link(prev),
+				Next:    link(next),
+				Maimais: permalinks,
+			})
+			if err != nil {
+				log.Error(err)
+			}
+			return
+		}
 
 		err = template.Execute(w, struct {
 			Maimais Week
 			Week    int
+			Prev    *CW
+			Next    *CW
+			Social  SocialMeta
 		}{
 			Maimais: *maimais,
 			Week:    week,
+			Prev:    prev,
+			Next:    next,
+			Social: newSocialMeta(r,
+				fmt.Sprintf("CW %d %d", week, year),
+				fmt.Sprintf("%d Maimais aus CW %d %d", len(maimais.Maimais), week, year),
+				maimais.Thumbnail(),
+			),
+		})
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+}
+
+func maimaiPage(template template.Template, source MaimaiSource, users []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		week, err := findWeek(source, r)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		counter, _ := strconv.Atoi(mux.Vars(r)["counter"])
+		if week == nil || week.Maimai(counter) == nil {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		err = template.Execute(w, struct {
+			Maimai   UserMaimai
+			Previous *UserMaimai
+			Next     *UserMaimai
+			User     string
+			Users    []string
+			BaseURL  string
+			Social   SocialMeta
+		}{
+			Maimai:   *week.Maimai(counter),
+			Previous: week.Previous(counter),
+			Next:     week.Next(counter),
+			User:     user,
+			Users:    users,
+			BaseURL:  baseURL(r),
+			Social: newSocialMeta(r,
+				week.Maimai(counter).Title(),
+				week.Maimai(counter).Description(),
+				*week.Maimai(counter),
+			),
 		})
 		if err != nil {
 			log.Error(err)
@@ -179,13 +346,16 @@ func faviconHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "static/favicon.ico")
 }
 
-func createRouter(templates *template.Template, source MaimaiSource, sub *Subscriptions) *mux.Router {
+func createRouter(templates *template.Template, source MaimaiSource, sub *Subscriptions, uploadOptions UploadOptions, admins []string, templateMasters []string, skipEmptyWeeks bool, searchIndex *SearchIndex, statsCache *StatsCache, wrappedCache WrappedCache) *mux.Router {
 
 	users, err := source.GetUsers()
 	if err != nil {
 		log.Fatalf("cannot load users: %s\n", err)
 	}
 
+	// admins can always upload templates
+	templateMasters = append(templateMasters, admins...)
+
 	r := mux.NewRouter().StrictSlash(false)
 	r.HandleFunc("/favicon.ico", faviconHandler)
 
@@ -193,35 +363,169 @@ func createRouter(templates *template.Template, source MaimaiSource, sub *Subscr
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
 
 	// file server for maimais
-	fsMaimais := http.FileServer(http.Dir(string(source)))
-	r.PathPrefix("/mm/").Handler(http.StripPrefix("/mm/", fsMaimais))
+	r.PathPrefix("/mm/").Handler(http.StripPrefix("/mm/", maimaiFileServer(source)))
 
-	r.HandleFunc("/", index(*templates.Lookup("index.html"), source, sub, users))
+	r.HandleFunc("/", index(*templates.Lookup("index.html"), source, sub, users, templateMasters))
 
 	r.HandleFunc("/sw.js", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./static/js/sw.js")
 	})
 
-	r.HandleFunc("/upload", uploadHandler(source, sub))
+	fetcher := NewURLFetcher(uploadOptions.MaxFileSize, 15*time.Second, false)
+	r.HandleFunc("/upload", uploadHandler(source, sub, fetcher, uploadOptions))
+
+	r.HandleFunc("/template", templateUploadHandler(source, sub, uploadOptions, templateMasters))
+
+	r.HandleFunc("/generator", generatorHandler(source, sub, uploadOptions))
 
 	r.HandleFunc("/subscribe", subscribe(sub))
 
+	r.HandleFunc("/oembed", oembed(source))
+
+	r.HandleFunc("/events", eventStream(Events))
+
+	r.HandleFunc("/admin/duplicates", duplicateReport(*templates.Lookup("duplicates.html"), source, admins))
+
+	r.HandleFunc("/search", searchHandler(*templates.Lookup("search.html"), source, searchIndex, users))
+
+	r.HandleFunc("/tags", tagPage(*templates.Lookup("tags.html"), source, searchIndex))
+
+	r.HandleFunc("/tags/{tag}", tagPage(*templates.Lookup("tags.html"), source, searchIndex))
+
+	r.HandleFunc("/stats", statsHandler(*templates.Lookup("stats.html"), source, statsCache))
+
+	r.HandleFunc("/wrapped/{year:202[0-9]}", wrappedHandler(*templates.Lookup("wrapped.html"), source, users, wrappedCache))
+
+	r.HandleFunc("/wrapped/{year:202[0-9]}/{user:[a-z]+}", wrappedHandler(*templates.Lookup("wrapped.html"), source, users, wrappedCache))
+
+	r.HandleFunc("/feed.atom", atomFeedHandler(source))
+
+	r.HandleFunc("/feed.json", jsonFeedHandler(source))
+
+	r.HandleFunc("/{year:202[0-9]}/{user:[a-z]+}/feed.atom", userAtomFeedHandler(source, users))
+
 	r.HandleFunc("/{year:202[0-9]}/{user:[a-z]+}", userContent(*templates.Lookup("user.html"), source, users))
 
-	r.HandleFunc("/{year:202[0-9]}", index(*templates.Lookup("index.html"), source, sub, users))
+	r.HandleFunc("/{year:202[0-9]}", index(*templates.Lookup("index.html"), source, sub, users, templateMasters))
+
+	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}", week(*templates.Lookup("week.html"), source, skipEmptyWeeks))
 
-	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}", week(*templates.Lookup("week.html"), source))
+	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}", maimaiPage(*templates.Lookup("maimai.html"), source, users))
+
+	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/caption", editCaption(source))
+
+	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/reactions", reactions(source, sub))
+
+	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/tags", editTags(source, sub))
+
+	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/delete", deleteMaimai(source, admins, uploadOptions.OriginalsDir))
+
+	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/comments", comments(source, sub, users))
+
+	r.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/comments/{id:[0-9]+}/{action:edit|delete}", editComment(source))
 
 	return r
 }
 
-func readFlags() (string, int, string, bool) {
+// Config holds the command line options
+type Config struct {
+	Directory string
+	Port      int
+	SubsDir   string
+	// DataDir keeps files the server creates, like the search index, outside of the served maimai directory
+	DataDir     string
+	NoCacheInit bool
+	Migrate     bool
+	// OriginalsDir keeps uploads before their metadata is removed
+	OriginalsDir string
+	// MaxUploadSize is the maximum file size in megabytes
+	MaxUploadSize int
+	MaxDimension  int
+	WeeklyQuota   int
+	// DuplicateWeeks is the number of weeks searched for reposts
+	DuplicateWeeks   int
+	RejectDuplicates bool
+	// Admins is a list of users that can see the admin pages
+	Admins []string
+	// TemplateMasters can upload the weekly templates
+	TemplateMasters []string
+	// Weeks defines the time zone and start of the calender weeks
+	Weeks WeekBoundary
+	// SkipEmptyWeeks skips weeks without maimais in the week navigation
+	SkipEmptyWeeks bool
+	// SearchIndex is the file of the search index
+	SearchIndex string
+	Reindex     bool
+	// OCR recognizes the text of uploads in the background if set
+	OCR *Tesseract
+}
+
+func readFlags() Config {
 	var directory = flag.String("dir", ".", "the maimai directory")
 	var port = flag.Int("port", 8080, "port to run on")
 	var subsDir = flag.String("subsdir", "/var/lib/mmotcw", "directory containing subscriptions, pub and priv-key")
+	var dataDir = flag.String("datadir", "/var/lib/mmotcw", "private directory for the search index and cached pages")
 	var noCacheInit = flag.Bool("no-cache-init", false, "Don't initialize image cache")
+	var migrate = flag.Bool("migrate", false, "backfill metadata files of all calender weeks and exit")
+	var originalsDir = flag.String("keep-originals", "", "private directory to keep uploads with their EXIF metadata in (disabled if empty)")
+	var maxUploadSize = flag.Int("max-upload-size", int(DefaultUploadOptions.MaxFileSize>>20), "maximum file size of uploads in megabytes (0 for unlimited)")
+	var maxDimension = flag.Int("max-dimension", DefaultUploadOptions.MaxDimension, "maximum width and height of uploaded images in pixels")
+	var weeklyQuota = flag.Int("weekly-quota", 0, "maximum number of uploads per user and week (0 for unlimited)")
+	var duplicateWeeks = flag.Int("duplicate-weeks", DefaultUploadOptions.DuplicateWeeks, "number of calender weeks searched for reposts of an upload (0 to disable)")
+	var rejectDuplicates = flag.Bool("reject-duplicates", false, "reject reposts instead of warning about them")
+	var admins = flag.String("admins", "", "comma separated list of admin users")
+	var templateMasters = flag.String("template-masters", "", "comma separated list of users who can upload the weekly template")
+	var timeZone = flag.String("timezone", "Local", "time zone of the group, e.g. Europe/Berlin")
+	var weekCutoff = flag.Int("week-cutoff", 0, "hour on monday the new calender week starts at")
+	var gracePeriod = flag.Duration("grace-period", 0, "time after the start of a week in which uploads can still go into the previous week")
+	var skipEmptyWeeks = flag.Bool("skip-empty-weeks", false, "skip weeks without maimais in the week navigation")
+	var searchIndex = flag.String("search-index", "", "file of the search index (default search.json in the data directory)")
+	var reindex = flag.Bool("reindex", false, "rebuild the search index from the maimai directory and exit")
+	var tesseract = flag.String("tesseract", "", "tesseract binary used to recognize the text of uploads (disabled if empty)")
+	var ocrLanguages = flag.String("ocr-languages", "deu+eng", "tesseract languages of the text recognition")
 	flag.Parse()
-	return *directory, *port, *subsDir, *noCacheInit
+
+	location, err := time.LoadLocation(*timeZone)
+	if err != nil {
+		log.Fatalf("unknown time zone %s: %v", *timeZone, err)
+	}
+	if *weekCutoff < 0 || *weekCutoff > 23 {
+		log.Fatalf("week cutoff must be an hour between 0 and 23")
+	}
+	var ocr *Tesseract
+	if len(*tesseract) > 0 {
+		binary, err := exec.LookPath(*tesseract)
+		if err != nil {
+			log.Fatalf("cannot find tesseract: %v", err)
+		}
+		ocr = &Tesseract{Binary: binary, Languages: *ocrLanguages}
+	}
+	return Config{
+		Directory:     *directory,
+		Port:          *port,
+		SubsDir:       *subsDir,
+		DataDir:       *dataDir,
+		NoCacheInit:   *noCacheInit,
+		Migrate:       *migrate,
+		OriginalsDir:  *originalsDir,
+		MaxUploadSize: *maxUploadSize,
+		MaxDimension:  *maxDimension,
+		WeeklyQuota:   *weeklyQuota,
+
+		DuplicateWeeks:   *duplicateWeeks,
+		RejectDuplicates: *rejectDuplicates,
+		Admins:           parseAdmins(*admins),
+		TemplateMasters:  parseAdmins(*templateMasters),
+		Weeks: WeekBoundary{
+			Location:    location,
+			CutoffHour:  *weekCutoff,
+			GracePeriod: *gracePeriod,
+		},
+		SkipEmptyWeeks: *skipEmptyWeeks,
+		SearchIndex:    *searchIndex,
+		Reindex:        *reindex,
+		OCR:            ocr,
+	}
 }
 
 // loadTemplates reads all .html files as templates from given directory
@@ -245,11 +549,9 @@ func loadTemplates(dir string) *template.Template {
 			}
 			return base64.RawStdEncoding.EncodeToString(b)
 		},
-		"formatTime": func(t time.Time) string {
-			w := t.Weekday()
-
-			weekdays := []string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"}
-			return fmt.Sprintf("%s %s", weekdays[w], t.Format("15:04"))
+		"formatTime": formatTime,
+		"card": func(m UserMaimai, user string) Card {
+			return Card{Maimai: m, User: user}
 		},
 		"capitalize": func(name string) string {
 			s := []rune(name)
@@ -273,36 +575,89 @@ func main() {
 	if os.Getenv("DEBUG") == "true" {
 		log = log.WithDebug()
 	}
-	miamaiDir, port, subsDir, skipCacheInit := readFlags()
+	config := readFlags()
+	source := MaimaiSource(config.Directory)
+	Weeks = config.Weeks
+
+	if config.Migrate {
+		if err := MigrateMetadata(source); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(config.SearchIndex) == 0 {
+		if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		config.SearchIndex = searchIndexPath(config.DataDir)
+	}
+	searchIndex, err := OpenSearchIndex(config.SearchIndex)
+	if err == nil && !config.Reindex {
+		if err := searchIndex.Refresh(source); err != nil {
+			log.Errorf("cannot refresh search index: %v", err)
+		}
+	}
+	if err != nil || config.Reindex {
+		if err != nil && !os.IsNotExist(err) {
+			log.Warnf("cannot read search index: %v", err)
+		}
+		log.Info("building search index")
+		if err := searchIndex.Rebuild(source); err != nil {
+			log.Fatal(err)
+		}
+		if config.Reindex {
+			return
+		}
+	}
+	go searchIndex.Watch(source, Events)
+	statsCache := NewStatsCache(source)
+	go statsCache.Watch(Events)
+
+	if config.OCR != nil {
+		go OCRJob{Source: source, Engine: *config.OCR, Bus: Events}.Run()
+	}
 
 	sub, err := ReadSubscriptions(
-		subsDir+"/sub_key",
-		subsDir+"/sub_key.pub",
-		subsDir+"/subscriptions",
+		config.SubsDir+"/sub_key",
+		config.SubsDir+"/sub_key.pub",
+		config.SubsDir+"/subscriptions",
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
+	schedulePending(source, sub)
 
 	templates := loadTemplates("./templates")
+	wrappedCache, err := NewWrappedCache(filepath.Join(config.DataDir, "wrapped"), "./templates")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	router := createRouter(templates, source, sub, UploadOptions{
+		OriginalsDir: config.OriginalsDir,
+		MaxFileSize:  int64(config.MaxUploadSize) << 20,
+		MaxDimension: config.MaxDimension,
+		WeeklyQuota:  config.WeeklyQuota,
 
-	source := MaimaiSource(miamaiDir)
-	router := createRouter(templates, source, sub)
+		DuplicateWeeks:   config.DuplicateWeeks,
+		RejectDuplicates: config.RejectDuplicates,
+	}, config.Admins, config.TemplateMasters, config.SkipEmptyWeeks, searchIndex, statsCache, wrappedCache)
 
 	http.Handle("/", router)
 
 	ImgCache.dir = string(source)
 
-	if !skipCacheInit {
+	if !config.NoCacheInit {
 		go func() {
-			err = FillCache()
+			err = InitCache(source)
 			if err != nil {
 				log.Fatal(err)
 			}
 		}()
 	}
 
-	serveOn := fmt.Sprintf("localhost:%d", port)
+	serveOn := fmt.Sprintf("localhost:%d", config.Port)
 	log.Infof("starting webserver on http://%s", serveOn)
 	if err := http.ListenAndServe(serveOn, nil); err != nil {
 		log.Fatal(err)
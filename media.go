@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// mediaTypes maps accepted mime types to the extension files are stored with
+var mediaTypes = map[string]string{
+	"image/gif":  "gif",
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/webp": "webp",
+	"video/mp4":  "mp4",
+	"video/webm": "webm",
+}
+
+// videoExtensions are the extensions of files that are rendered as videos
+var videoExtensions = []string{"mp4", "webm"}
+
+// posterFolder is the folder in a CW folder that holds the poster frames of videos
+const posterFolder = "posters"
+
+// errNoFFmpeg is returned if a video frame is needed but ffmpeg is not installed
+var errNoFFmpeg = errors.New("ffmpeg is not installed")
+
+// extensionForType returns the file extension used to store files of a mime type
+// returns false if the type is not accepted
+func extensionForType(mimeType string) (string, bool) {
+	ext, ok := mediaTypes[mimeType]
+	return ext, ok
+}
+
+// mimeTypeForExtension returns the mime type of files with the extension
+func mimeTypeForExtension(ext string) string {
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	for mimeType, e := range mediaTypes {
+		if e == ext {
+			return mimeType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// isMediaExtension checks if files with the extension are maimais
+func isMediaExtension(ext string) bool {
+	if ext == "jpeg" {
+		return true
+	}
+	for _, e := range mediaTypes {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func isVideoExtension(ext string) bool {
+	return contains(videoExtensions, strings.ToLower(ext))
+}
+
+// decodeFrame decodes an image or the first frame of a video or animation
+// Files that cannot be decoded in Go are passed to ffmpeg if it is installed.
+func decodeFrame(filePath string) (image.Image, error) {
+	if !isVideoExtension(strings.TrimPrefix(filepath.Ext(filePath), ".")) {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err == nil {
+			return img, nil
+		}
+		// e.g. animated webp, which the webp decoder does not support
+		log.Debugf("cannot decode %s, trying ffmpeg: %v", filePath, err)
+	}
+	return extractFrame(filePath)
+}
+
+// extractFrame uses ffmpeg to extract the first frame of a file
+func extractFrame(filePath string) (image.Image, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, errNoFFmpeg
+	}
+	cmd := exec.Command(ffmpeg, "-v", "error", "-i", filePath, "-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-")
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, stderr.String())
+	}
+	return png.Decode(bytes.NewReader(out))
+}
+
+// EnsurePoster creates the poster frame of a video maimai if it does not exist yet
+func (m MaimaiSource) EnsurePoster(mm UserMaimai) error {
+	posterPath := filepath.Join(string(m), mm.PosterHref())
+	if _, err := os.Stat(posterPath); err == nil {
+		return nil
+	}
+	frame, err := decodeFrame(m.FilePath(mm))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(posterPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(posterPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return jpeg.Encode(f, frame, nil)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testWebP is a lossless WebP image of 1x1 pixels
+const testWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func TestMediaTypes(t *testing.T) {
+	tests := []struct {
+		ext      string
+		mimeType string
+		media    bool
+		video    bool
+	}{
+		{"jpg", "image/jpeg", true, false},
+		{"jpeg", "image/jpeg", true, false},
+		{"png", "image/png", true, false},
+		{"gif", "image/gif", true, false},
+		{"webp", "image/webp", true, false},
+		{"mp4", "video/mp4", true, true},
+		{"webm", "video/webm", true, true},
+		{"MP4", "application/octet-stream", false, true},
+		{"json", "application/octet-stream", false, false},
+		{"", "application/octet-stream", false, false},
+	}
+	for _, test := range tests {
+		if mimeType := mimeTypeForExtension(test.ext); mimeType != test.mimeType {
+			t.Errorf("expected mime type %s for %q, got %s", test.mimeType, test.ext, mimeType)
+		}
+		if media := isMediaExtension(test.ext); media != test.media {
+			t.Errorf("expected %q to be a maimai: %v", test.ext, test.media)
+		}
+		if video := isVideoExtension(test.ext); video != test.video {
+			t.Errorf("expected %q to be a video: %v", test.ext, test.video)
+		}
+		// stored files get the extension of their mime type back
+		if ext, ok := extensionForType(test.mimeType); test.media && (!ok || mimeTypeForExtension(ext) != test.mimeType) {
+			t.Errorf("expected an extension for %s, got %q", test.mimeType, ext)
+		}
+	}
+	if _, ok := extensionForType("image/svg+xml"); ok {
+		t.Error("expected svg not to be accepted")
+	}
+}
+
+func TestGetImageFiles(t *testing.T) {
+	folder := t.TempDir()
+	for _, name := range []string{"1_hans_1.jpeg", "2_hans_2.mp4", "3_fritz_1.webm", "template.png", "notes.txt", metadataFile, "meta.json.tmp"} {
+		if err := os.WriteFile(filepath.Join(folder, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// poster frames are in their own folder
+	if err := os.MkdirAll(filepath.Join(folder, posterFolder, "2_hans_2.jpg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files, err := GetImageFiles(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	expected := []string{"1_hans_1.jpeg", "2_hans_2.mp4", "3_fritz_1.webm", "template.png"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+	}
+}
+
+func TestPosters(t *testing.T) {
+	webp, err := base64.StdEncoding.DecodeString(testWebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// animated gif, the first frame is the poster
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{
+		Image: []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 30, 20), palette), image.NewPaletted(image.Rect(0, 0, 30, 20), palette)},
+		Delay: []int{10, 10},
+	}
+	animated := bytes.NewBuffer(nil)
+	if err := gif.EncodeAll(animated, animation); err != nil {
+		t.Fatal(err)
+	}
+
+	source := MaimaiSource(t.TempDir())
+	cw := CW{Year: 2021, Week: 1}
+	tests := []struct {
+		maimai UserMaimai
+		data   []byte
+		size   image.Point
+	}{
+		{UserMaimai{User: "hans", Counter: 1, UserCounter: 1, ImageType: "webp", CW: cw}, webp, image.Pt(1, 1)},
+		{UserMaimai{User: "hans", Counter: 2, UserCounter: 2, ImageType: "gif", CW: cw}, animated.Bytes(), image.Pt(30, 20)},
+	}
+	for _, test := range tests {
+		path := source.FilePath(test.maimai)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		frame, err := decodeFrame(path)
+		if err != nil {
+			t.Fatalf("cannot decode %s: %v", test.maimai.ImageType, err)
+		}
+		if frame.Bounds().Size() != test.size {
+			t.Errorf("expected a frame of %v for %s, got %v", test.size, test.maimai.ImageType, frame.Bounds().Size())
+		}
+
+		if err := source.EnsurePoster(test.maimai); err != nil {
+			t.Fatal(err)
+		}
+		poster, err := os.Open(filepath.Join(string(source), test.maimai.PosterHref()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, format, err := image.DecodeConfig(poster)
+		poster.Close()
+		if err != nil || format != "jpeg" || config.Width != test.size.X || config.Height != test.size.Y {
+			t.Errorf("expected a jpeg poster of %v for %s, got %s %dx%d %v", test.size, test.maimai.ImageType, format, config.Width, config.Height, err)
+		}
+	}
+
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		t.Skip("ffmpeg is installed")
+	}
+	video := UserMaimai{User: "hans", Counter: 3, UserCounter: 3, ImageType: "mp4", CW: cw}
+	if err := os.WriteFile(source.FilePath(video), []byte("kein video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.EnsurePoster(video); err != errNoFFmpeg {
+		t.Errorf("expected an error without ffmpeg, got %v", err)
+	}
+}
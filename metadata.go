@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataFile is the name of the sidecar file in every CW folder
+const metadataFile = "meta.json"
+
+// maxCaptionLength limits the number of characters of captions and alt texts
+const maxCaptionLength = 280
+
+// metadataLock synchronizes all reads and writes of sidecar files
+var metadataLock sync.Mutex
+
+// Metadata holds information about a maimai that is not encoded in its file name
+type Metadata struct {
+	// Uploader is the user who uploaded the file
+	Uploader UserName `json:"uploader,omitempty"`
+
+	// UploadTime is the original upload time, which survives copies and restores
+	UploadTime time.Time `json:"uploadTime"`
+
+	// OriginalName is the name of the file on the uploaders device
+	OriginalName string `json:"originalName,omitempty"`
+
+	// SourceURL is the url the file was fetched from for uploads by url
+	SourceURL string `json:"sourceUrl,omitempty"`
+
+	// Hash is the hex encoded SHA-256 of the file content
+	Hash string `json:"sha256,omitempty"`
+
+	// Width and Height are the image dimensions in pixels
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// PerceptualHash is the hex encoded dHash used to find reposts
+	PerceptualHash string `json:"dhash,omitempty"`
+
+	// Caption is shown in the card overlay
+	Caption string `json:"caption,omitempty"`
+
+	// AltText describes the image for screen readers
+	AltText string `json:"alt,omitempty"`
+
+	// PublishAt is the time the maimai becomes visible, zero if it is visible right away
+	PublishAt time.Time `json:"publishAt,omitempty"`
+
+	// OCRText is the text recognized in the image
+	OCRText string `json:"ocr,omitempty"`
+
+	// OCRTime is the time the text recognition ran, zero if it did not run yet
+	OCRTime time.Time `json:"ocrTime,omitempty"`
+
+	// OCRErrors is the number of failed attempts of the text recognition
+	OCRErrors int `json:"ocrErrors,omitempty"`
+
+	// Tags are set by the uploader
+	Tags []string `json:"tags,omitempty"`
+
+	// SuggestedTags maps tags suggested by other users to the users who suggested them
+	SuggestedTags map[string][]UserName `json:"suggestedTags,omitempty"`
+
+	// Template is the file name of the template the maimai is based on
+	Template string `json:"template,omitempty"`
+
+	// Reactions maps emojis to the users who reacted with them
+	Reactions map[string][]UserName `json:"reactions,omitempty"`
+
+	// Comments is the comment thread of the maimai, oldest first
+	Comments []Comment `json:"comments,omitempty"`
+}
+
+// WeekMetadata maps the file names of a week's maimais to their metadata
+type WeekMetadata map[string]Metadata
+
+// ReadMetadata reads the metadata sidecar of a calender week
+// A missing sidecar file is not an error and results in empty metadata
+func (m MaimaiSource) ReadMetadata(cw CW) (WeekMetadata, error) {
+	metadataLock.Lock()
+	defer metadataLock.Unlock()
+	return m.readMetadata(cw)
+}
+
+// UpdateMetadata changes the metadata of a single maimai and saves the sidecar file
+func (m MaimaiSource) UpdateMetadata(cw CW, fileName string, update func(*Metadata)) error {
+	return m.UpdateWeekMetadata(cw, func(meta WeekMetadata) error {
+		entry := meta[fileName]
+		update(&entry)
+		meta[fileName] = entry
+		return nil
+	})
+}
+
+// UpdateWeekMetadata changes the metadata of a calender week and saves the sidecar file
+// Nothing is saved if update returns an error
+func (m MaimaiSource) UpdateWeekMetadata(cw CW, update func(WeekMetadata) error) error {
+	metadataLock.Lock()
+	defer metadataLock.Unlock()
+
+	meta, err := m.readMetadata(cw)
+	if err != nil {
+		return err
+	}
+	if err := update(meta); err != nil {
+		return err
+	}
+	return m.writeMetadata(cw, meta)
+}
+
+func (m MaimaiSource) readMetadata(cw CW) (WeekMetadata, error) {
+	meta := WeekMetadata{}
+	data, err := os.ReadFile(filepath.Join(string(m), cw.Path(), metadataFile))
+	if os.IsNotExist(err) {
+		return meta, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// writeMetadata saves the sidecar file via a temporary file
+// so readers never see a partially written file
+func (m MaimaiSource) writeMetadata(cw CW, meta WeekMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(string(m), cw.Path(), metadataFile)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// FillFileInfo sets hash and dimensions from the file content if they are missing
+func (meta *Metadata) FillFileInfo(filePath string) error {
+	if len(meta.Hash) > 0 && meta.Width > 0 && meta.Height > 0 {
+		return nil
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	meta.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(f)
+	if err == nil {
+		meta.Width, meta.Height = config.Width, config.Height
+		return nil
+	}
+	// videos and formats without Go decoder
+	frame, err := decodeFrame(filePath)
+	if err != nil {
+		return err
+	}
+	meta.Width, meta.Height = frame.Bounds().Dx(), frame.Bounds().Dy()
+	return nil
+}
+
+// cleanCaption trims whitespace and limits the length of user supplied text
+func cleanCaption(s string) string {
+	s = strings.TrimSpace(s)
+	runes := []rune(s)
+	if len(runes) > maxCaptionLength {
+		runes = runes[:maxCaptionLength]
+	}
+	return string(runes)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestMaimais creates the files in the source directory with a png as content
+func writeTestMaimais(t *testing.T, source MaimaiSource, paths ...string) {
+	for _, path := range paths {
+		path = filepath.Join(string(source), path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, testPNG(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateMetadata(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	writeTestMaimais(t, source, "2020/CW_53/1_hans_1.png", "2021/CW_01/1_fritz_1.png", "2021/CW_01/template.png", "2021/CW_01/invalid.png")
+	cw := CW{Year: 2021, Week: 1}
+	err := source.UpdateMetadata(cw, "1_fritz_1.png", func(m *Metadata) {
+		m.Caption = "schon da"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateMetadata(source); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := source.ReadMetadata(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta) != 1 {
+		t.Errorf("expected only the maimai in the sidecar file, got %v", meta)
+	}
+	fritz := meta["1_fritz_1.png"]
+	if fritz.Uploader != "fritz" || fritz.Caption != "schon da" || fritz.UploadTime.IsZero() ||
+		len(fritz.Hash) == 0 || fritz.Width != 20 || fritz.Height != 10 || len(fritz.PerceptualHash) == 0 {
+		t.Errorf("expected the missing fields to be filled in, got %+v", fritz)
+	}
+	if hans, _ := source.ReadMetadata(CW{Year: 2020, Week: 53}); hans["1_hans_1.png"].Uploader != "hans" {
+		t.Errorf("expected all years to be migrated, got %v", hans)
+	}
+
+	// a second run keeps the upload time even if the file was touched
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(string(source), "2021/CW_01/1_fritz_1.png"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateMetadata(source); err != nil {
+		t.Fatal(err)
+	}
+	again, err := source.ReadMetadata(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again["1_fritz_1.png"].UploadTime.Equal(fritz.UploadTime) || again["1_fritz_1.png"].Hash != fritz.Hash {
+		t.Errorf("expected the second run to change nothing, got %+v", again["1_fritz_1.png"])
+	}
+}
+
+func TestUpdateMetadataConcurrently(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	writeTestMaimais(t, source, "2021/CW_01/1_hans_1.png")
+	cw := CW{Year: 2021, Week: 1}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := source.UpdateMetadata(cw, "1_hans_1.png", func(m *Metadata) {
+				m.ToggleReaction(ReactionEmojis[0], UserName(fmt.Sprintf("user%d", i)))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	meta, err := source.ReadMetadata(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := meta["1_hans_1.png"].ReactionCount(); n != 50 {
+		t.Errorf("expected no update to get lost, got %d reactions", n)
+	}
+}
+
+func TestCorruptMetadata(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	writeTestMaimais(t, source, "2021/CW_01/1_hans_1.png")
+	cw := CW{Year: 2021, Week: 1}
+	file := filepath.Join(string(source), cw.Path(), metadataFile)
+	if err := os.WriteFile(file, []byte(`{"1_hans_1.png": {"caption": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the week can still be shown
+	week, err := source.GetMaimaisForCW(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(week.Maimais) != 1 {
+		t.Errorf("expected the maimai without metadata, got %v", week.Maimais)
+	}
+
+	// but the file is not overwritten
+	err = source.UpdateMetadata(cw, "1_hans_1.png", func(m *Metadata) {
+		m.Caption = "kaputt"
+	})
+	if err == nil {
+		t.Error("expected an error for a corrupt sidecar file")
+	}
+	if data, _ := os.ReadFile(file); string(data) != `{"1_hans_1.png": {"caption": ` {
+		t.Errorf("expected the corrupt sidecar file to stay untouched, got %s", data)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// MigrateMetadata backfills the metadata sidecar files of all existing CW folders
+// Only missing fields are filled in, so running it more than once is safe
+func MigrateMetadata(source MaimaiSource) error {
+	for _, year := range source.GetYears() {
+		cws, err := source.GetCWsOfYear(year)
+		if err != nil {
+			return err
+		}
+		for _, cw := range cws {
+			if err := migrateWeek(source, cw); err != nil {
+				return err
+			}
+		}
+		log.Infof("migrated metadata for %d calender weeks of year %d", len(cws), year)
+	}
+	return nil
+}
+
+func migrateWeek(source MaimaiSource, cw CW) error {
+	folder := filepath.Join(string(source), cw.Path())
+	imgFiles, err := GetImageFiles(folder)
+	if err != nil {
+		return err
+	}
+	return source.UpdateWeekMetadata(cw, func(meta WeekMetadata) error {
+		for _, img := range imgFiles {
+			if isTemplateFile(img.Name()) {
+				continue
+			}
+			mm, err := NewUserMaimai(img.Name(), img.ModTime(), cw)
+			if err != nil {
+				log.Warnf("skipping %s/%s: %v", cw.Path(), img.Name(), err)
+				continue
+			}
+			entry := meta[img.Name()]
+			if len(entry.Uploader) == 0 {
+				entry.Uploader = mm.User
+			}
+			if entry.UploadTime.IsZero() {
+				entry.UploadTime = img.ModTime()
+			}
+			if err := entry.FillFileInfo(filepath.Join(folder, img.Name())); err != nil {
+				log.Warnf("cannot read %s/%s: %v", cw.Path(), img.Name(), err)
+			}
+			if err := entry.FillPerceptualHash(filepath.Join(folder, img.Name())); err != nil {
+				log.Warnf("cannot hash %s/%s: %v", cw.Path(), img.Name(), err)
+			}
+			meta[img.Name()] = entry
+		}
+		return nil
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EventOCR is published when the text of the maimais of a week was recognized
+const EventOCR = "ocr"
+
+// ocrTimeout is the time tesseract gets for an image if no timeout is configured
+const ocrTimeout = time.Minute
+
+// ocrMaxAttempts is the number of times the text recognition is tried for a readable image
+const ocrMaxAttempts = 3
+
+// OCREngine recognizes the text in an image file
+type OCREngine interface {
+	Recognize(path string) (string, error)
+}
+
+// Tesseract recognizes text with a locally installed tesseract binary
+type Tesseract struct {
+	Binary string
+	// Languages are the tesseract language codes, e.g. deu+eng
+	Languages string
+	// Timeout stops tesseract if it takes longer, ocrTimeout is used if it is zero
+	Timeout time.Duration
+}
+
+// Recognize runs tesseract on the image and returns the cleaned up text
+func (t Tesseract) Recognize(path string) (string, error) {
+	args := []string{path, "stdout"}
+	if len(t.Languages) > 0 {
+		args = append(args, "-l", t.Languages)
+	}
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = ocrTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, t.Binary, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("tesseract took longer than %v for %s", timeout, path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("tesseract failed for %s: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return cleanOCRText(string(out)), nil
+}
+
+// cleanOCRText joins the recognized lines and drops words without letters or digits,
+// which are mostly noise from the image
+func cleanOCRText(text string) string {
+	words := []string{}
+	for _, word := range strings.Fields(text) {
+		if strings.IndexFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// OCRJob recognizes the text of all maimais and templates
+// The result is stored in the metadata of every file, files that already have one are skipped.
+// Images that cannot be decoded are marked as processed right away, other failures
+// are counted and tried again with the next run up to ocrMaxAttempts times.
+type OCRJob struct {
+	Source MaimaiSource
+	Engine OCREngine
+	Bus    *EventBus
+}
+
+// RunOnce processes all files that were not processed yet and returns their number
+// The result of every file is saved right away, so an interrupted run continues where it stopped.
+func (job OCRJob) RunOnce() (int, error) {
+	processed := 0
+	for _, year := range job.Source.GetYears() {
+		cws, err := job.Source.GetCWsOfYear(year)
+		if err != nil {
+			return processed, err
+		}
+		for _, cw := range cws {
+			n, err := job.processWeek(cw)
+			processed += n
+			if err != nil {
+				return processed, err
+			}
+		}
+	}
+	return processed, nil
+}
+
+func (job OCRJob) processWeek(cw CW) (int, error) {
+	week, err := job.Source.GetMaimaisForCW(cw)
+	if err != nil {
+		return 0, err
+	}
+	files := []Maimai{}
+	for _, m := range append(week.Maimais, week.Scheduled...) {
+		if !m.IsVideo() && m.Meta.OCRTime.IsZero() {
+			files = append(files, m)
+		}
+	}
+	for _, t := range append(week.Templates, week.ScheduledTemplates...) {
+		if t.Meta.OCRTime.IsZero() {
+			files = append(files, t)
+		}
+	}
+
+	for _, file := range files {
+		path := job.Source.FilePath(file)
+		text, err := job.Engine.Recognize(path)
+		failed := err != nil
+		if failed {
+			log.Error(err)
+		}
+		err = job.Source.UpdateMetadata(cw, file.FileName(), func(meta *Metadata) {
+			if failed {
+				meta.OCRErrors++
+				// timeouts and a broken installation are tried again, unreadable images not
+				if isDecodable(path) && meta.OCRErrors < ocrMaxAttempts {
+					return
+				}
+			}
+			meta.OCRText = text
+			meta.OCRTime = time.Now()
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(files) > 0 && job.Bus != nil {
+		job.Bus.Publish(Event{Type: EventOCR, CW: cw})
+	}
+	return len(files), nil
+}
+
+// isDecodable checks if the file is an image that can be read
+func isDecodable(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, _, err = image.DecodeConfig(f)
+	return err == nil
+}
+
+// Run processes all files and then the new ones after every upload
+// It never returns and should run in its own goroutine.
+func (job OCRJob) Run() {
+	events, _ := job.Bus.Subscribe()
+
+	// uploads during a run trigger one more run
+	wake := make(chan struct{}, 1)
+	wake <- struct{}{}
+	go func() {
+		for range wake {
+			start := time.Now()
+			n, err := job.RunOnce()
+			if err != nil {
+				log.Errorf("text recognition stopped: %v", err)
+			}
+			if n > 0 {
+				log.Infof("recognized text of %d files in %v", n, time.Since(start))
+			}
+		}
+	}()
+
+	for e := range events {
+		if e.Type != EventUpload && e.Type != EventTemplate {
+			continue
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	close(wake)
+}
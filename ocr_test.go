@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeOCR returns the file name as text and records the recognized files
+type fakeOCR struct {
+	files []string
+	fail  map[string]bool
+}
+
+func (f *fakeOCR) Recognize(path string) (string, error) {
+	name := filepath.Base(path)
+	f.files = append(f.files, name)
+	if f.fail[name] {
+		return "", errors.New("cannot read image")
+	}
+	return "Text von " + name, nil
+}
+
+func TestOCRJob(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	cw := CW{Year: 2021, Week: 5}
+	folder := filepath.Join(string(source), cw.Path())
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1_hans_1.png", "2_fritz_1.png", "3_hans_2.mp4", "template.png"} {
+		if err := os.WriteFile(filepath.Join(folder, name), testPNG(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(folder, "5_fritz_2.png"), []byte("kaputt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := source.UpdateMetadata(cw, "2_fritz_1.png", func(meta *Metadata) {
+		meta.AltText = "Eine Taube"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := &fakeOCR{fail: map[string]bool{"template.png": true, "5_fritz_2.png": true}}
+	bus := NewEventBus()
+	events, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+	job := OCRJob{Source: source, Engine: engine, Bus: bus}
+	n, err := job.RunOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// videos are skipped
+	if n != 4 || len(engine.files) != 4 {
+		t.Fatalf("expected 4 processed files, got %d: %v", n, engine.files)
+	}
+	if e := <-events; e.Type != EventOCR || e.CW != cw {
+		t.Errorf("expected ocr event for %v, got %+v", cw, e)
+	}
+
+	week, err := source.GetMaimaisForCW(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := week.Maimai(1); m.Meta.OCRText != "Text von 1_hans_1.png" || m.Alt() != m.Meta.OCRText {
+		t.Errorf("expected the recognized text as alt text, got %q", m.Alt())
+	}
+	if m := week.Maimai(2); m.Alt() != "Eine Taube" {
+		t.Errorf("expected the alt text to be kept, got %q", m.Alt())
+	}
+	// unreadable images are not tried again, other failures are
+	if m := week.Maimai(5); m.Meta.OCRTime.IsZero() {
+		t.Errorf("expected the unreadable maimai to be marked, got %+v", m.Meta)
+	}
+	if template := week.Templates[0]; !template.Meta.OCRTime.IsZero() || template.Meta.OCRErrors != 1 {
+		t.Errorf("expected the failed template to be counted, got %+v", template.Meta)
+	}
+
+	// a second run processes new files and the failed ones
+	if err := os.WriteFile(filepath.Join(folder, "4_hans_3.png"), testPNG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	engine.files = nil
+	if n, err := job.RunOnce(); err != nil || n != 2 || strings.Join(engine.files, " ") != "4_hans_3.png template.png" {
+		t.Errorf("expected the new file and the template to be processed, got %d %v: %v", n, engine.files, err)
+	}
+	// until they failed too often
+	for i := 2; i < ocrMaxAttempts; i++ {
+		if _, err := job.RunOnce(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	engine.files = nil
+	if n, err := job.RunOnce(); err != nil || n != 0 {
+		t.Errorf("expected the template to be given up, got %d %v: %v", n, engine.files, err)
+	}
+
+	index, _ := OpenSearchIndex("")
+	if err := index.Rebuild(source); err != nil {
+		t.Fatal(err)
+	}
+	if results := index.Search(SearchQuery{Text: "text 4_hans"}); len(results) != 1 || results[0].Counter != 4 {
+		t.Errorf("expected the recognized text to be searchable, got %v", results)
+	}
+}
+
+func TestTesseractTimeout(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "tesseract")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err := Tesseract{Binary: binary, Timeout: 100 * time.Millisecond}.Recognize("maimai.png")
+	if err == nil || !strings.Contains(err.Error(), "longer") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("tesseract was not stopped after the timeout")
+	}
+}
+
+func TestCleanOCRText(t *testing.T) {
+	text := cleanOCRText("WENN DU\n\n  MAIMAIS | ~ \n— POSTEST\n\f")
+	if text != "WENN DU MAIMAIS POSTEST" {
+		t.Errorf("unexpected text %q", text)
+	}
+}
+
+func TestTesseract(t *testing.T) {
+	binary, err := exec.LookPath("tesseract")
+	if err != nil {
+		t.Skip("tesseract is not installed")
+	}
+	path := filepath.Join(t.TempDir(), "text.png")
+	if err := os.WriteFile(path, testPNG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Tesseract{Binary: binary, Languages: "eng"}).Recognize(path); err != nil {
+		t.Error(err)
+	}
+}
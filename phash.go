@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"github.com/nfnt/resize"
+)
+
+// duplicateDistance is the maximum number of differing bits of two perceptual hashes
+// for the images to be considered the same maimai
+const duplicateDistance = 6
+
+// duplicateBands is the number of parts the hashes are split into to find similar ones:
+// two hashes with at most duplicateDistance differing bits are equal in at least one part
+const duplicateBands = duplicateDistance + 1
+
+// PerceptualHash is a difference hash (dHash) of an image
+// Similar images have hashes with a small hamming distance,
+// even if they are scaled, recompressed or slightly edited.
+type PerceptualHash uint64
+
+// DHash computes the difference hash of an image
+// see http://www.hackerfactor.com/blog/?/archives/529-Kind-of-Like-That.html
+func DHash(img image.Image) PerceptualHash {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	b := small.Bounds()
+	var hash PerceptualHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(b.Min.X+x, b.Min.Y+y)).(color.Gray)
+			right := color.GrayModel.Convert(small.At(b.Min.X+x+1, b.Min.Y+y)).(color.Gray)
+			hash <<= 1
+			if left.Y > right.Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// ParsePerceptualHash parses a hash formatted with String
+func ParsePerceptualHash(s string) (PerceptualHash, error) {
+	h, err := strconv.ParseUint(s, 16, 64)
+	return PerceptualHash(h), err
+}
+
+// String formats the hash as 16 hex digits
+func (h PerceptualHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Distance returns the number of bits that differ between two hashes
+func (h PerceptualHash) Distance(h2 PerceptualHash) int {
+	return bits.OnesCount64(uint64(h ^ h2))
+}
+
+// FillPerceptualHash sets the perceptual hash from the file content if it is missing
+// Videos are hashed by their first frame.
+func (meta *Metadata) FillPerceptualHash(filePath string) error {
+	if len(meta.PerceptualHash) > 0 {
+		return nil
+	}
+	img, err := decodeFrame(filePath)
+	if err != nil {
+		return err
+	}
+	meta.PerceptualHash = DHash(img).String()
+	return nil
+}
+
+// IsDuplicateOf checks if two maimais look (almost) the same
+func (mm UserMaimai) IsDuplicateOf(other UserMaimai) bool {
+	h1, err := ParsePerceptualHash(mm.Meta.PerceptualHash)
+	if err != nil {
+		return false
+	}
+	h2, err := ParsePerceptualHash(other.Meta.PerceptualHash)
+	if err != nil {
+		return false
+	}
+	return h1.Distance(h2) <= duplicateDistance
+}
+
+// FindDuplicate searches the calender week of the maimai's upload and the weeks before it
+// for a maimai that looks the same
+// weeks is the number of calender weeks that are searched, the maimai itself is skipped
+func (m MaimaiSource) FindDuplicate(mm UserMaimai, weeks int) *UserMaimai {
+	if len(mm.Meta.PerceptualHash) == 0 {
+		return nil
+	}
+	for i := 0; i < weeks; i++ {
+		cw := Weeks.CWAt(mm.UploadTime.AddDate(0, 0, -7*i))
+		meta, err := m.ReadMetadata(cw)
+		if err != nil {
+			log.Warnf("cannot read metadata of %s: %v", cw.Path(), err)
+			continue
+		}
+		// look at the files in a stable order
+		names := make([]string, 0, len(meta))
+		for name := range meta {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if cw == mm.CW && name == mm.FileName() {
+				continue
+			}
+			other, err := NewUserMaimai(name, meta[name].UploadTime, cw)
+			if err != nil {
+				continue
+			}
+			other.Meta = meta[name]
+			if mm.IsDuplicateOf(*other) {
+				return other
+			}
+		}
+	}
+	return nil
+}
+
+// DuplicateClusters groups all maimais of all years that look the same
+// Only groups with more than one maimai are returned, the newest groups first
+func (m MaimaiSource) DuplicateClusters() ([][]UserMaimai, error) {
+	maimais := []UserMaimai{}
+	hashes := []PerceptualHash{}
+	for _, year := range m.GetYears() {
+		cws, err := m.GetCWsOfYear(year)
+		if err != nil {
+			return nil, err
+		}
+		for _, cw := range cws {
+			week, err := m.GetMaimaisForCW(cw)
+			if err != nil {
+				return nil, err
+			}
+			for _, mm := range week.Maimais {
+				if hash, err := ParsePerceptualHash(mm.Meta.PerceptualHash); err == nil {
+					maimais = append(maimais, mm)
+					hashes = append(hashes, hash)
+				}
+			}
+		}
+	}
+
+	groups := map[int][]UserMaimai{}
+	for i, root := range similarHashes(hashes) {
+		groups[root] = append(groups[root], maimais[i])
+	}
+	clusters := [][]UserMaimai{}
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].UploadTime.Before(group[j].UploadTime)
+		})
+		clusters = append(clusters, group)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][len(clusters[i])-1].UploadTime.After(clusters[j][len(clusters[j])-1].UploadTime)
+	})
+	return clusters, nil
+}
+
+// similarHashes groups hashes that are at most duplicateDistance bits apart, also transitively
+// returns the index of a representative of the group for every hash
+// Only hashes that are equal in one of the bands are compared instead of all pairs.
+func similarHashes(hashes []PerceptualHash) []int {
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	bandBits := (64 + duplicateBands - 1) / duplicateBands
+	for band := 0; band < duplicateBands; band++ {
+		buckets := map[uint64][]int{}
+		for i, hash := range hashes {
+			key := uint64(hash) >> (band * bandBits) & (1<<bandBits - 1)
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, bucket := range buckets {
+			for a, i := range bucket {
+				for _, j := range bucket[a+1:] {
+					if find(i) != find(j) && hashes[i].Distance(hashes[j]) <= duplicateDistance {
+						parent[find(j)] = find(i)
+					}
+				}
+			}
+		}
+	}
+
+	roots := make([]int, len(hashes))
+	for i := range hashes {
+		roots[i] = find(i)
+	}
+	return roots
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nfnt/resize"
+)
+
+// patternImage draws diagonal stripes, the direction depends on flip
+func patternImage(w, h int, flip bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := (x*255/w + y*128/h) % 256
+			if flip {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	buffer := bytes.NewBuffer(nil)
+	if err := png.Encode(buffer, img); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestDHash(t *testing.T) {
+	original := patternImage(300, 200, false)
+	scaled := resize.Resize(150, 100, original, resize.Bilinear)
+	other := patternImage(300, 200, true)
+
+	if d := DHash(original).Distance(DHash(scaled)); d > duplicateDistance {
+		t.Errorf("expected scaled image to be a duplicate, distance is %d", d)
+	}
+	if d := DHash(original).Distance(DHash(other)); d <= duplicateDistance {
+		t.Errorf("expected different image not to be a duplicate, distance is %d", d)
+	}
+
+	hash := DHash(original)
+	parsed, err := ParsePerceptualHash(hash.String())
+	if err != nil || parsed != hash {
+		t.Errorf("expected %s to be parsed, got %s %v", hash, parsed, err)
+	}
+}
+
+func TestUploadDuplicates(t *testing.T) {
+	first := encodePNG(t, patternImage(300, 200, false))
+	repost := encodePNG(t, resize.Resize(150, 100, patternImage(300, 200, false), resize.Bilinear))
+
+	upload := func(handler http.HandlerFunc, data []byte) UploadResult {
+		req := multipartUpload(t, data)
+		req.Header.Set("Accept", "application/json")
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		results := []UploadResult{}
+		if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil || len(results) != 1 {
+			t.Fatalf("unexpected response %d: %s", resp.Code, resp.Body.String())
+		}
+		return results[0]
+	}
+
+	source := MaimaiSource(t.TempDir())
+	fetcher := NewURLFetcher(1<<20, time.Second, false)
+	warn := uploadHandler(source, &Subscriptions{}, fetcher, DefaultUploadOptions)
+	stored := upload(warn, first)
+	if len(stored.Error) > 0 || len(stored.Duplicate) > 0 {
+		t.Fatalf("expected first upload to be stored without warning, got %+v", stored)
+	}
+	if result := upload(warn, repost); len(result.Error) > 0 || result.Duplicate != stored.URL {
+		t.Errorf("expected repost to be stored with a warning, got %+v", result)
+	}
+
+	options := DefaultUploadOptions
+	options.RejectDuplicates = true
+	reject := uploadHandler(source, &Subscriptions{}, fetcher, options)
+	if result := upload(reject, repost); result.Status != http.StatusConflict {
+		t.Errorf("expected repost to be rejected, got %+v", result)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(string(source), "*", "*", "*.tmp")); len(tmp) > 0 {
+		t.Errorf("expected no temporary files, got %v", tmp)
+	}
+
+	// a repost in the same batch is compared with the file before it
+	batch := MaimaiSource(t.TempDir())
+	req := multipartUpload(t, first, repost)
+	req.Header.Set("Accept", "application/json")
+	resp := httptest.NewRecorder()
+	uploadHandler(batch, &Subscriptions{}, fetcher, DefaultUploadOptions)(resp, req)
+	results := []UploadResult{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil || len(results) != 2 {
+		t.Fatalf("unexpected response %d: %s", resp.Code, resp.Body.String())
+	}
+	if len(results[0].Duplicate) > 0 || results[1].Duplicate != results[0].URL {
+		t.Errorf("expected the second file of the batch to be a duplicate of the first, got %+v", results)
+	}
+
+	clusters, err := source.DuplicateClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || len(clusters[0]) != 2 {
+		t.Errorf("expected one cluster of two maimais, got %v", clusters)
+	}
+}
+
+func TestSimilarHashes(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	hashes := []PerceptualHash{}
+	for i := 0; i < 200; i++ {
+		hash := PerceptualHash(random.Uint64())
+		// the same image with the most differing bits that still count as duplicate
+		similar := hash
+		for _, bit := range random.Perm(64)[:duplicateDistance] {
+			similar ^= 1 << bit
+		}
+		hashes = append(hashes, hash, similar)
+	}
+
+	roots := similarHashes(hashes)
+	for i := 0; i < len(hashes); i++ {
+		for j := i + 1; j < len(hashes); j++ {
+			if hashes[i].Distance(hashes[j]) <= duplicateDistance && roots[i] != roots[j] {
+				t.Errorf("%v and %v are similar but not grouped", hashes[i], hashes[j])
+			}
+		}
+	}
+	groups := map[int]int{}
+	for _, root := range roots {
+		groups[root]++
+	}
+	// random hashes are about 32 bits apart
+	if len(groups) != len(hashes)/2 {
+		t.Errorf("expected %d groups, got %d", len(hashes)/2, len(groups))
+	}
+}
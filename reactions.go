@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ReactionEmojis are the emojis users can react with
+var ReactionEmojis = []string{"😂", "🔥", "👍", "🤔", "😍", "💩"}
+
+// Reaction is an emoji and the users who reacted with it
+type Reaction struct {
+	Emoji string
+	Users []UserName
+}
+
+// By checks if the user reacted with the emoji
+func (r Reaction) By(user string) bool {
+	for _, u := range r.Users {
+		if strings.EqualFold(string(u), user) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReactionList returns the reactions for all emojis in the order of ReactionEmojis
+func (meta Metadata) ReactionList() []Reaction {
+	reactions := make([]Reaction, len(ReactionEmojis))
+	for i, emoji := range ReactionEmojis {
+		reactions[i] = Reaction{Emoji: emoji, Users: meta.Reactions[emoji]}
+	}
+	return reactions
+}
+
+// ToggleReaction adds the reaction of the user or removes it if it already exists
+// returns true if the reaction was added
+func (meta *Metadata) ToggleReaction(emoji string, user UserName) bool {
+	if meta.Reactions == nil {
+		meta.Reactions = map[string][]UserName{}
+	}
+	users := meta.Reactions[emoji]
+	for i, u := range users {
+		if strings.EqualFold(string(u), string(user)) {
+			users = append(users[:i], users[i+1:]...)
+			if len(users) == 0 {
+				delete(meta.Reactions, emoji)
+			} else {
+				meta.Reactions[emoji] = users
+			}
+			return false
+		}
+	}
+	meta.Reactions[emoji] = append(users, user)
+	return true
+}
+
+// ReactionCount returns the number of reactions of all users
+func (meta Metadata) ReactionCount() int {
+	count := 0
+	for _, users := range meta.Reactions {
+		count += len(users)
+	}
+	return count
+}
+
+func isReactionEmoji(emoji string) bool {
+	for _, e := range ReactionEmojis {
+		if e == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// reactions returns the reactions of a maimai as JSON (GET)
+// or toggles the reaction of the current user (POST)
+func reactions(source MaimaiSource, s *Subscriptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maimai, err := findMaimai(source, r)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if maimai == nil {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeReactions(w, maimai.Meta)
+		case http.MethodPost:
+			user, _, ok := r.BasicAuth()
+			if !ok {
+				httpError(w, http.StatusUnauthorized)
+				return
+			}
+			emoji := r.FormValue("emoji")
+			if !isReactionEmoji(emoji) {
+				httpError(w, http.StatusBadRequest)
+				return
+			}
+
+			var added bool
+			var meta Metadata
+			err := source.UpdateMetadata(maimai.CW, maimai.FileName(), func(m *Metadata) {
+				added = m.ToggleReaction(emoji, UserName(user))
+				meta = *m
+			})
+			if err != nil {
+				log.Error(err)
+				httpError(w, http.StatusInternalServerError)
+				return
+			}
+
+			Events.Publish(NewMaimaiEvent(EventReaction, *maimai, user))
+
+			if added && !maimai.By(user) {
+				go s.SendTo(string(maimai.User), fmt.Sprintf("%s hat mit %s auf dein Maimai reagiert", user, emoji))
+			}
+
+			if wantsJSON(r) {
+				writeReactions(w, meta)
+			} else {
+				redirectBack(w, r, "/"+maimai.CW.Path())
+			}
+		default:
+			httpError(w, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func writeReactions(w http.ResponseWriter, meta Metadata) {
+	reactions := meta.Reactions
+	if reactions == nil {
+		reactions = map[string][]UserName{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reactions); err != nil {
+		log.Error(err)
+	}
+}
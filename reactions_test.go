@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/gorilla/mux"
+)
+
+// pushSubscriptions subscribes the user to push notifications that are counted by the returned counter
+func pushSubscriptions(t *testing.T, user string) (*Subscriptions, *int32) {
+	var pushes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pushes, 1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+
+	s := &Subscriptions{privateKey: privateKey, publicKey: publicKey, subscriptionsFile: t.TempDir() + "/subscriptions"}
+	body := fmt.Sprintf(`{"endpoint": %q, "keys": {"p256dh": %q, "auth": %q}}`, server.URL,
+		base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), base64.RawURLEncoding.EncodeToString(auth))
+	if err := s.Add(user, []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	return s, &pushes
+}
+
+func TestReactionsHandler(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	writeTestMaimais(t, source, "2021/CW_05/1_hans_1.png")
+	s, pushes := pushSubscriptions(t, "hans")
+	router := mux.NewRouter()
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/reactions", reactions(source, s))
+
+	react := func(user string, url string, emoji string) *httptest.ResponseRecorder {
+		form := "emoji=" + emoji
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth(user, "")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	decode := func(resp *httptest.ResponseRecorder) map[string][]UserName {
+		var reactions map[string][]UserName
+		if err := json.NewDecoder(resp.Body).Decode(&reactions); err != nil {
+			t.Fatal(err)
+		}
+		return reactions
+	}
+	fire := url.QueryEscape("🔥")
+
+	// reactions to the own maimai are not pushed
+	resp := react("hans", "/2021/CW_05/1/reactions", fire)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected status 200 with JSON, got %d", resp.Code)
+	}
+	if reactions := decode(resp); len(reactions) != 1 || len(reactions["🔥"]) != 1 || reactions["🔥"][0] != "hans" {
+		t.Errorf("expected the reaction of hans, got %v", reactions)
+	}
+	if reactions := decode(react("fritz", "/2021/CW_05/1/reactions", fire)); len(reactions["🔥"]) != 2 {
+		t.Errorf("expected two reactions, got %v", reactions)
+	}
+	for start := time.Now(); atomic.LoadInt32(pushes) == 0 && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(pushes); n != 1 {
+		t.Errorf("expected one push notification for the reaction of fritz, got %d", n)
+	}
+
+	// a second reaction removes it
+	if reactions := decode(react("fritz", "/2021/CW_05/1/reactions", fire)); len(reactions["🔥"]) != 1 || reactions["🔥"][0] != "hans" {
+		t.Errorf("expected the reaction of fritz to be removed, got %v", reactions)
+	}
+	if reactions := decode(react("Hans", "/2021/CW_05/1/reactions", fire)); len(reactions) != 0 {
+		t.Errorf("expected no reactions, got %v", reactions)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(pushes); n != 1 {
+		t.Errorf("expected no push notification for removed reactions, got %d", n)
+	}
+
+	if resp := react("fritz", "/2021/CW_05/1/reactions", url.QueryEscape("🦆")); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an emoji that is not allowed, got %d", resp.Code)
+	}
+	for _, u := range []string{"/2021/CW_05/2/reactions", "/2021/CW_06/1/reactions"} {
+		if resp := react("fritz", u, fire); resp.Code != http.StatusNotFound {
+			t.Errorf("expected status 404 for %s, got %d", u, resp.Code)
+		}
+	}
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/2021/CW_05/1/reactions", nil))
+	if reactions := decode(resp); resp.Code != http.StatusOK || reactions == nil || len(reactions) != 0 {
+		t.Errorf("expected an empty JSON object, got %d %v", resp.Code, reactions)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+var errInvalidJPEG = errors.New("invalid jpeg")
+var errInvalidPNG = errors.New("invalid png")
+
+// exifOrientationTag is the TIFF tag of the EXIF orientation
+const exifOrientationTag = 0x0112
+
+// jpegDroppedMarkers are the JPEG segments removed from uploads:
+// APP1 (EXIF, XMP), APP13 (IPTC, Photoshop) and comments
+var jpegDroppedMarkers = map[byte]bool{0xE1: true, 0xED: true, 0xFE: true}
+
+// pngDroppedChunks are the PNG chunks removed from uploads
+var pngDroppedChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// pngColorChunkTypes are the PNG chunks kept when an image is re-encoded
+var pngColorChunkTypes = map[string]bool{"iCCP": true, "sRGB": true, "gAMA": true, "cHRM": true}
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// SanitizeImage removes metadata like EXIF, XMP, text chunks and locations from uploads
+// The EXIF orientation of JPEG and PNG files is applied to the pixels, which requires
+// re-encoding the image, the color profile is kept. Images without orientation are only
+// stripped, so the image data is not touched. WebP files are only stripped, Go cannot encode
+// them. Metadata boxes of videos are blanked in place, so the offsets into the media data stay valid.
+// Other formats are returned unchanged.
+func SanitizeImage(data []byte, ext string) ([]byte, error) {
+	switch ext {
+	case "jpg", "jpeg":
+		stripped, orientation, err := stripJPEG(data)
+		if err != nil {
+			return nil, err
+		}
+		if orientation <= 1 || orientation > 8 {
+			return stripped, nil
+		}
+		img, err := jpeg.Decode(bytes.NewReader(stripped))
+		if err != nil {
+			return nil, err
+		}
+		buffer := bytes.NewBuffer(nil)
+		if err := jpeg.Encode(buffer, orient(img, orientation), &jpeg.Options{Quality: 92}); err != nil {
+			return nil, err
+		}
+		// the encoder writes no color profile, it follows the start of image marker
+		encoded := buffer.Bytes()
+		return concat(encoded[:2], jpegColorSegments(stripped), encoded[2:]), nil
+	case "png":
+		stripped, orientation, err := stripPNG(data)
+		if err != nil {
+			return nil, err
+		}
+		if orientation <= 1 || orientation > 8 {
+			return stripped, nil
+		}
+		img, err := png.Decode(bytes.NewReader(stripped))
+		if err != nil {
+			return nil, err
+		}
+		buffer := bytes.NewBuffer(nil)
+		if err := png.Encode(buffer, orient(img, orientation)); err != nil {
+			return nil, err
+		}
+		// color chunks must come before the image data, the encoder starts with the IHDR chunk
+		encoded := buffer.Bytes()
+		headerEnd := len(pngSignature) + 12 + 13
+		return concat(encoded[:headerEnd], pngColorChunks(stripped), encoded[headerEnd:]), nil
+	case "webp":
+		return stripWebP(data)
+	case "mp4":
+		return stripMP4(data)
+	case "webm":
+		return stripWebM(data)
+	}
+	return data, nil
+}
+
+func concat(parts ...[]byte) []byte {
+	out := []byte{}
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// jpegColorSegments returns the APP2 segments with the ICC color profile
+func jpegColorSegments(data []byte) []byte {
+	segments := []byte{}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) {
+			break
+		}
+		if marker == 0xE2 && bytes.HasPrefix(data[i+4:end], []byte("ICC_PROFILE\x00")) {
+			segments = append(segments, data[i:end]...)
+		}
+		i = end
+	}
+	return segments
+}
+
+// pngColorChunks returns the chunks that describe the color space
+func pngColorChunks(data []byte) []byte {
+	chunks := []byte{}
+	for i := len(pngSignature); i+8 <= len(data); {
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:i+4]))
+		if end > len(data) {
+			break
+		}
+		if pngColorChunkTypes[string(data[i+4:i+8])] {
+			chunks = append(chunks, data[i:end]...)
+		}
+		i = end
+	}
+	return chunks
+}
+
+// stripJPEG removes all metadata segments and returns the EXIF orientation (0 if unknown)
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errInvalidJPEG
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	orientation := 0
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, 0, errInvalidJPEG
+		}
+		// skip fill bytes
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, 0, errInvalidJPEG
+		}
+		marker := data[i+1]
+		// markers without payload
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		if marker == 0xD9 {
+			out.Write(data[i : i+2])
+			break
+		}
+		if i+4 > len(data) {
+			return nil, 0, errInvalidJPEG
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errInvalidJPEG
+		}
+		segment := data[i:end]
+		if marker == 0xE1 && bytes.HasPrefix(segment[4:], []byte("Exif\x00\x00")) {
+			orientation = exifOrientation(segment[10:])
+		}
+		if !jpegDroppedMarkers[marker] {
+			out.Write(segment)
+		}
+		if marker == 0xDA {
+			// start of scan, the entropy coded data follows
+			out.Write(data[end:])
+			break
+		}
+		i = end
+	}
+	return out.Bytes(), orientation, nil
+}
+
+// stripPNG removes all text, time and EXIF chunks and returns the EXIF orientation (0 if unknown)
+func stripPNG(data []byte) ([]byte, int, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, 0, errInvalidPNG
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	orientation := 0
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, 0, errInvalidPNG
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, 0, errInvalidPNG
+		}
+		if chunkType == "eXIf" {
+			orientation = exifOrientation(data[i+8 : i+8+length])
+		}
+		if !pngDroppedChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), orientation, nil
+}
+
+// exifOrientation reads the orientation tag from the first IFD of TIFF encoded EXIF data
+// returns 0 if the tag is missing or the data is invalid
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) || offset < 8 {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[offset : offset+2]))
+	for e := 0; e < entries; e++ {
+		entry := offset + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == exifOrientationTag {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 0
+}
+
+// orient transforms the image so it is displayed upright for the EXIF orientation
+// see https://magnushoff.com/articles/jpeg-orientation/
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+var errInvalidWebP = errors.New("invalid webp")
+
+// webpDroppedChunks are the WebP chunks removed from uploads
+var webpDroppedChunks = map[string]bool{"EXIF": true, "XMP ": true}
+
+// webpCanvasSize reads the canvas size from the VP8X chunk of an extended WebP file
+func webpCanvasSize(data []byte) (int, int, error) {
+	if len(data) < 30 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" || string(data[12:16]) != "VP8X" {
+		return 0, 0, errInvalidWebP
+	}
+	// width and height minus one as 24 bit little endian numbers after the flags
+	width := int(data[24]) | int(data[25])<<8 | int(data[26])<<16
+	height := int(data[27]) | int(data[28])<<8 | int(data[29])<<16
+	return width + 1, height + 1, nil
+}
+
+// stripWebP removes the EXIF and XMP chunks of a WebP file
+// The flags of the VP8X chunk are cleared as well, so decoders don't look for them.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errInvalidWebP
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errInvalidWebP
+		}
+		chunkType := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		// chunks are padded to an even size
+		end := i + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			return nil, errInvalidWebP
+		}
+		switch {
+		case webpDroppedChunks[chunkType]:
+		case chunkType == "VP8X" && size > 0:
+			start := out.Len()
+			out.Write(data[i:end])
+			// EXIF and XMP flags
+			out.Bytes()[start+8] &^= 0x08 | 0x04
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:8], uint32(len(stripped)-8))
+	return stripped, nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// gpsSecret is stored in the GPS tags of the fixtures
+const gpsSecret = "52.5163 N 13.3777 E"
+
+// exifFixture creates TIFF encoded EXIF data with an orientation and a GPS IFD
+func exifFixture(orientation uint16) []byte {
+	order := binary.BigEndian
+	tiff := bytes.NewBuffer(nil)
+	tiff.WriteString("MM")
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8))
+
+	entry := func(tag, typ uint16, count, value uint32) {
+		binary.Write(tiff, order, tag)
+		binary.Write(tiff, order, typ)
+		binary.Write(tiff, order, count)
+		binary.Write(tiff, order, value)
+	}
+
+	// IFD0: orientation and pointer to GPS IFD
+	gpsOffset := uint32(8 + 2 + 2*12 + 4)
+	binary.Write(tiff, order, uint16(2))
+	entry(exifOrientationTag, 3, 1, uint32(orientation)<<16)
+	entry(0x8825, 4, 1, gpsOffset)
+	binary.Write(tiff, order, uint32(0))
+
+	// GPS IFD: map datum as ASCII containing the secret
+	dataOffset := gpsOffset + 2 + 12 + 4
+	binary.Write(tiff, order, uint16(1))
+	entry(0x0012, 2, uint32(len(gpsSecret)+1), dataOffset)
+	binary.Write(tiff, order, uint32(0))
+	tiff.WriteString(gpsSecret + "\x00")
+	return tiff.Bytes()
+}
+
+// testImage has a red pixel in the top left corner
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	return img
+}
+
+func jpegFixture(t *testing.T, orientation uint16) []byte {
+	buffer := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buffer, testImage(64, 32), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buffer.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), exifFixture(orientation)...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	comment := []byte{0xFF, 0xFE, 0, 0}
+	binary.BigEndian.PutUint16(comment[2:], uint16(len(gpsSecret)+2))
+	comment = append(comment, gpsSecret...)
+
+	fixture := append([]byte{}, encoded[:2]...)
+	fixture = append(fixture, app1...)
+	fixture = append(fixture, comment...)
+	return append(fixture, encoded[2:]...)
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+func pngFixture(t *testing.T, orientation uint16) []byte {
+	buffer := bytes.NewBuffer(nil)
+	if err := png.Encode(buffer, testImage(64, 32)); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buffer.Bytes()
+	// signature and IHDR chunk
+	headerEnd := 8 + 12 + 13
+
+	fixture := append([]byte{}, encoded[:headerEnd]...)
+	fixture = append(fixture, pngChunk("eXIf", exifFixture(orientation))...)
+	fixture = append(fixture, pngChunk("tEXt", []byte("Comment\x00"+gpsSecret))...)
+	return append(fixture, encoded[headerEnd:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func TestSanitizeImage(t *testing.T) {
+	cases := []struct {
+		name       string
+		data       []byte
+		ext        string
+		size       image.Point
+		redAt      image.Point
+		unmodified bool
+	}{
+		{"jpeg upright", jpegFixture(t, 1), "jpg", image.Pt(64, 32), image.Pt(0, 0), true},
+		{"jpeg rotated 90", jpegFixture(t, 6), "jpg", image.Pt(32, 64), image.Pt(31, 0), false},
+		{"jpeg rotated 180", jpegFixture(t, 3), "jpg", image.Pt(64, 32), image.Pt(63, 31), false},
+		{"jpeg rotated 270", jpegFixture(t, 8), "jpg", image.Pt(32, 64), image.Pt(0, 63), false},
+		{"png upright", pngFixture(t, 1), "png", image.Pt(64, 32), image.Pt(0, 0), true},
+		{"png mirrored", pngFixture(t, 2), "png", image.Pt(64, 32), image.Pt(63, 0), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if !bytes.Contains(c.data, []byte(gpsSecret)) {
+				t.Fatal("fixture does not contain gps data")
+			}
+			sanitized, err := SanitizeImage(c.data, c.ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(sanitized, []byte(gpsSecret)) {
+				t.Error("gps data was not removed")
+			}
+			if bytes.Contains(sanitized, []byte("Exif\x00\x00")) {
+				t.Error("exif data was not removed")
+			}
+
+			img, _, err := image.Decode(bytes.NewReader(sanitized))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := img.Bounds().Size(); size != c.size {
+				t.Errorf("expected size %v, got %v", c.size, size)
+			}
+			if !isRed(img.At(c.redAt.X, c.redAt.Y)) {
+				t.Errorf("expected red corner at %v", c.redAt)
+			}
+
+			if c.unmodified {
+				original, _, err := image.Decode(bytes.NewReader(c.data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for y := 0; y < c.size.Y; y++ {
+					for x := 0; x < c.size.X; x++ {
+						if original.At(x, y) != img.At(x, y) {
+							t.Fatalf("pixel (%d,%d) changed although no rotation was needed", x, y)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStoreUploadKeepsOriginal(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	originals := t.TempDir()
+	cw := CW{Year: 2021, Week: 5}
+	if err := os.MkdirAll(filepath.Join(string(source), cw.Path()), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := jpegFixture(t, 6)
+	target := UserMaimai{User: "hans", Counter: 1, UserCounter: 0, CW: cw}
+	options := UploadOptions{OriginalsDir: originals, MaxFileSize: 1 << 20}
+	prepared, err := prepareUpload(source, options, cw, bytes.NewReader(fixture), Metadata{
+		OriginalName: "IMG_0001.jpg",
+		UploadTime:   time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	maimai, err := storeUpload(source, options, target, prepared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := os.ReadFile(source.FilePath(*maimai))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte(gpsSecret)) {
+		t.Error("stored maimai contains gps data")
+	}
+	original, err := os.ReadFile(filepath.Join(originals, maimai.Href()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(original, fixture) {
+		t.Error("original was not kept unmodified")
+	}
+	if maimai.Meta.Width != 32 || maimai.Meta.Height != 64 {
+		t.Errorf("expected rotated dimensions 32x64, got %dx%d", maimai.Meta.Width, maimai.Meta.Height)
+	}
+}
+
+// webpFixture extends the lossless test image with EXIF and XMP chunks
+func webpFixture(t *testing.T) []byte {
+	simple, err := base64.StdEncoding.DecodeString(testWebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(chunkType string, data []byte) []byte {
+		c := make([]byte, 8, 9+len(data))
+		copy(c, chunkType)
+		binary.LittleEndian.PutUint32(c[4:], uint32(len(data)))
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	// EXIF and XMP flags, canvas of 1x1 pixels
+	vp8x := []byte{0x08 | 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	fixture := []byte("RIFF\x00\x00\x00\x00WEBP")
+	fixture = append(fixture, chunk("VP8X", vp8x)...)
+	fixture = append(fixture, simple[12:]...)
+	fixture = append(fixture, chunk("EXIF", exifFixture(6))...)
+	fixture = append(fixture, chunk("XMP ", []byte("<x:xmpmeta>"+gpsSecret+"</x:xmpmeta>"))...)
+	binary.LittleEndian.PutUint32(fixture[4:], uint32(len(fixture)-8))
+	return fixture
+}
+
+// mp4Box creates a MP4 box with the given children or payload
+func mp4Box(boxType string, payload ...[]byte) []byte {
+	box := make([]byte, 8)
+	copy(box[4:], boxType)
+	for _, p := range payload {
+		box = append(box, p...)
+	}
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	return box
+}
+
+func mp4Fixture() []byte {
+	return bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")),
+		mp4Box("moov",
+			mp4Box("mvhd", make([]byte, 100)),
+			mp4Box("trak", mp4Box("tkhd", make([]byte, 84)), mp4Box("meta", []byte(gpsSecret))),
+			mp4Box("udta", mp4Box("\xa9xyz", []byte("+52.5163+013.3777/"+gpsSecret))),
+		),
+		mp4Box("uuid", []byte("be7acfcb97a942e89c71999491e3afac<x:xmpmeta>"+gpsSecret)),
+		mp4Box("mdat", []byte("frames")),
+	}, nil)
+}
+
+// ebmlElement creates a Matroska element with a one byte or unknown size
+func ebmlElement(id []byte, payload ...[]byte) []byte {
+	element := append([]byte{}, id...)
+	if len(payload) == 0 {
+		return append(element, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	}
+	body := bytes.Join(payload, nil)
+	return append(append(element, 0x80|byte(len(body))), body...)
+}
+
+func webmFixture() []byte {
+	return bytes.Join([][]byte{
+		ebmlElement([]byte{0x1A, 0x45, 0xDF, 0xA3}, ebmlElement([]byte{0x42, 0x82}, []byte("webm"))),
+		// segment and cluster of unknown size as written by browsers
+		ebmlElement([]byte{0x18, 0x53, 0x80, 0x67}),
+		ebmlElement([]byte{0x15, 0x49, 0xA9, 0x66}, ebmlElement([]byte{0x2A, 0xD7, 0xB1}, []byte{0x0F, 0x42, 0x40})),
+		ebmlElement([]byte{0x1F, 0x43, 0xB6, 0x75}),
+		ebmlElement([]byte{0xE7}, []byte{0x00}),
+		ebmlElement([]byte{0xA3}, []byte("frame")),
+		ebmlElement([]byte{0x12, 0x54, 0xC3, 0x67},
+			ebmlElement([]byte{0x73, 0x73}, ebmlElement([]byte{0x67, 0xC8},
+				ebmlElement([]byte{0x45, 0xA3}, []byte("LOCATION")),
+				ebmlElement([]byte{0x44, 0x87}, []byte(gpsSecret)),
+			)),
+		),
+	}, nil)
+}
+
+func TestSanitizeWebP(t *testing.T) {
+	fixture := webpFixture(t)
+	sanitized, err := SanitizeImage(fixture, "webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sanitized, []byte(gpsSecret)) || bytes.Contains(sanitized, []byte("EXIF")) {
+		t.Error("metadata was not removed")
+	}
+	if size := binary.LittleEndian.Uint32(sanitized[4:8]); int(size) != len(sanitized)-8 {
+		t.Errorf("expected riff size %d, got %d", len(sanitized)-8, size)
+	}
+	if flags := sanitized[20]; flags&(0x08|0x04) != 0 {
+		t.Errorf("exif and xmp flags are still set: %#x", flags)
+	}
+	img, err := webp.Decode(bytes.NewReader(sanitized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(1, 1) {
+		t.Errorf("expected size 1x1, got %v", size)
+	}
+	if _, err := SanitizeImage([]byte("RIFF\x00\x00\x00\x00WEBPVP8L"), "webp"); err == nil {
+		t.Error("truncated webp was accepted")
+	}
+}
+
+func TestSanitizeVideo(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		ext  string
+		kept []string
+	}{
+		{"mp4", mp4Fixture(), "mp4", []string{"ftyp", "mvhd", "tkhd", "mdat", "frames"}},
+		{"webm", webmFixture(), "webm", []string{"webm", "frame"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sanitized, err := SanitizeImage(c.data, c.ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(sanitized, []byte(gpsSecret)) || bytes.Contains(sanitized, []byte("LOCATION")) {
+				t.Error("metadata was not removed")
+			}
+			// offsets into the media data must not change
+			if len(sanitized) != len(c.data) {
+				t.Errorf("expected %d bytes, got %d", len(c.data), len(sanitized))
+			}
+			for _, kept := range c.kept {
+				if !bytes.Contains(sanitized, []byte(kept)) {
+					t.Errorf("%q was removed", kept)
+				}
+			}
+			if _, err := SanitizeImage(c.data[:len(c.data)-3], c.ext); err == nil {
+				t.Error("truncated file was accepted")
+			}
+		})
+	}
+
+	t.Run("free boxes", func(t *testing.T) {
+		sanitized, err := SanitizeImage(mp4Fixture(), "mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(sanitized, []byte("udta")) || bytes.Contains(sanitized, []byte("uuid")) {
+			t.Error("metadata boxes were not replaced")
+		}
+		if n := bytes.Count(sanitized, []byte("free")); n != 3 {
+			t.Errorf("expected 3 free boxes, got %d", n)
+		}
+	})
+}
+
+func TestSanitizeKeepsColorProfile(t *testing.T) {
+	profile := []byte("ICC_PROFILE\x00\x01\x01fake color profile")
+	app2 := []byte{0xFF, 0xE2, 0, 0}
+	binary.BigEndian.PutUint16(app2[2:], uint16(len(profile)+2))
+	app2 = append(app2, profile...)
+	jpegData := jpegFixture(t, 6)
+	jpegData = append(append(append([]byte{}, jpegData[:2]...), app2...), jpegData[2:]...)
+
+	iccp := pngChunk("iCCP", []byte("fake\x00\x00profile"))
+	pngData := pngFixture(t, 2)
+	headerEnd := 8 + 12 + 13
+	pngData = append(append(append([]byte{}, pngData[:headerEnd]...), iccp...), pngData[headerEnd:]...)
+
+	cases := []struct {
+		name    string
+		data    []byte
+		ext     string
+		profile []byte
+	}{
+		{"jpeg", jpegData, "jpg", app2},
+		{"png", pngData, "png", iccp},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sanitized, err := SanitizeImage(c.data, c.ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(sanitized, c.profile) {
+				t.Error("color profile was removed")
+			}
+			if _, _, err := image.Decode(bytes.NewReader(sanitized)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
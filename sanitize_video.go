@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errInvalidMP4 = errors.New("invalid mp4")
+var errInvalidWebM = errors.New("invalid webm")
+
+// mp4BlankedBoxes are the MP4 boxes turned into free space: udta holds the location
+// of phones (©xyz), meta the location and device of Apple devices and uuid XMP data
+var mp4BlankedBoxes = map[string]bool{"udta": true, "meta": true, "uuid": true}
+
+// mp4ContainerBoxes are the MP4 boxes whose children are searched for metadata
+var mp4ContainerBoxes = map[string]bool{"moov": true, "trak": true}
+
+// stripMP4 turns the metadata boxes of a MP4 file into zeroed free boxes
+// The boxes keep their size, so the chunk offsets into the media data stay valid.
+func stripMP4(data []byte) ([]byte, error) {
+	if len(data) < 8 || string(data[4:8]) != "ftyp" {
+		return nil, errInvalidMP4
+	}
+	out := append([]byte{}, data...)
+	if err := blankMP4Boxes(out, 0, len(out)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func blankMP4Boxes(data []byte, start, end int) error {
+	for i := start; i < end; {
+		if i+8 > end {
+			return errInvalidMP4
+		}
+		size := int(binary.BigEndian.Uint32(data[i : i+4]))
+		boxType := string(data[i+4 : i+8])
+		header := 8
+		switch size {
+		case 0:
+			// the box extends to the end of the file
+			size = end - i
+		case 1:
+			if i+16 > end {
+				return errInvalidMP4
+			}
+			large := binary.BigEndian.Uint64(data[i+8 : i+16])
+			if large > uint64(end-i) {
+				return errInvalidMP4
+			}
+			size, header = int(large), 16
+		}
+		if size < header || i+size > end {
+			return errInvalidMP4
+		}
+		switch {
+		case mp4BlankedBoxes[boxType]:
+			copy(data[i+4:i+8], "free")
+			for j := i + header; j < i+size; j++ {
+				data[j] = 0
+			}
+		case mp4ContainerBoxes[boxType]:
+			if err := blankMP4Boxes(data, i+header, i+size); err != nil {
+				return err
+			}
+		}
+		i += size
+	}
+	return nil
+}
+
+// Matroska element IDs
+const (
+	ebmlHeaderID  = 0x1A45DFA3
+	ebmlSegmentID = 0x18538067
+	ebmlVoidID    = 0xEC
+)
+
+// webmBlankedElements are the segment children replaced by void elements:
+// tags (location, encoder, title) and attachments
+var webmBlankedElements = map[uint64]bool{0x1254C367: true, 0x1941A469: true}
+
+// webmSegmentChildren are the top level elements of a segment,
+// they end a cluster of unknown size like the ones written by browsers
+var webmSegmentChildren = map[uint64]bool{
+	0x114D9B74: true, 0x1549A966: true, 0x1654AE6B: true, 0x1C53BB6B: true,
+	0x1F43B675: true, 0x1043A770: true, 0x1254C367: true, 0x1941A469: true,
+}
+
+// stripWebM replaces the tags and attachments of a WebM file with void elements of the same size
+func stripWebM(data []byte) ([]byte, error) {
+	out := append([]byte{}, data...)
+	id, idLen, err := readEBMLID(out, 0)
+	if err != nil || id != ebmlHeaderID {
+		return nil, errInvalidWebM
+	}
+	for i := 0; i < len(out); {
+		id, idLen, err = readEBMLID(out, i)
+		if err != nil {
+			return nil, err
+		}
+		size, sizeLen, known, err := readEBMLSize(out, i+idLen)
+		if err != nil {
+			return nil, err
+		}
+		start := i + idLen + sizeLen
+		end := len(out)
+		if known {
+			if size > uint64(len(out)-start) {
+				return nil, errInvalidWebM
+			}
+			end = start + int(size)
+		}
+		if id == ebmlSegmentID {
+			if err := blankWebMSegment(out, start, end); err != nil {
+				return nil, err
+			}
+		}
+		i = end
+	}
+	return out, nil
+}
+
+func blankWebMSegment(data []byte, start, end int) error {
+	for i := start; i < end; {
+		id, idLen, err := readEBMLID(data, i)
+		if err != nil {
+			return err
+		}
+		size, sizeLen, known, err := readEBMLSize(data, i+idLen)
+		if err != nil {
+			return err
+		}
+		childStart := i + idLen + sizeLen
+		childEnd := end
+		if known {
+			if size > uint64(end-childStart) {
+				return errInvalidWebM
+			}
+			childEnd = childStart + int(size)
+		} else if childEnd, err = webmUnknownEnd(data, childStart, end); err != nil {
+			return err
+		}
+		if webmBlankedElements[id] {
+			if !known {
+				return errInvalidWebM
+			}
+			writeEBMLVoid(data[i:childEnd])
+		}
+		i = childEnd
+	}
+	return nil
+}
+
+// webmUnknownEnd finds the end of an element of unknown size,
+// it ends with the next top level element of the segment
+func webmUnknownEnd(data []byte, start, end int) (int, error) {
+	for i := start; i < end; {
+		id, idLen, err := readEBMLID(data, i)
+		if err != nil {
+			return 0, err
+		}
+		if webmSegmentChildren[id] {
+			return i, nil
+		}
+		size, sizeLen, known, err := readEBMLSize(data, i+idLen)
+		if err != nil {
+			return 0, err
+		}
+		if !known || size > uint64(end-i-idLen-sizeLen) {
+			return 0, errInvalidWebM
+		}
+		i += idLen + sizeLen + int(size)
+	}
+	return end, nil
+}
+
+// readEBMLID reads an element ID, the length marker is part of the ID
+func readEBMLID(data []byte, i int) (uint64, int, error) {
+	if i >= len(data) || data[i] == 0 {
+		return 0, 0, errInvalidWebM
+	}
+	length := 1
+	for data[i]&(0x80>>(length-1)) == 0 {
+		length++
+	}
+	if length > 4 || i+length > len(data) {
+		return 0, 0, errInvalidWebM
+	}
+	id := uint64(0)
+	for _, b := range data[i : i+length] {
+		id = id<<8 | uint64(b)
+	}
+	return id, length, nil
+}
+
+// readEBMLSize reads the size of an element, known is false if all bits are set
+func readEBMLSize(data []byte, i int) (size uint64, length int, known bool, err error) {
+	if i >= len(data) || data[i] == 0 {
+		return 0, 0, false, errInvalidWebM
+	}
+	length = 1
+	for data[i]&(0x80>>(length-1)) == 0 {
+		length++
+	}
+	if i+length > len(data) {
+		return 0, 0, false, errInvalidWebM
+	}
+	size = uint64(data[i] & (0xFF >> length))
+	for _, b := range data[i+1 : i+length] {
+		size = size<<8 | uint64(b)
+	}
+	return size, length, size != 1<<(7*length)-1, nil
+}
+
+// writeEBMLVoid overwrites the element with a zeroed void element of the same length
+func writeEBMLVoid(element []byte) {
+	// a one byte size holds at most 126, longer elements use eight bytes
+	sizeLen := 1
+	if len(element) > 8 {
+		sizeLen = 8
+	}
+	for j := range element {
+		element[j] = 0
+	}
+	element[0] = ebmlVoidID
+	size := uint64(len(element)-1-sizeLen) | 1<<(7*sizeLen)
+	for j := sizeLen; j > 0; j-- {
+		element[j] = byte(size)
+		size >>= 8
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Weeks defines the calender weeks of the group, it is configured on startup
+var Weeks = WeekBoundary{Location: time.Local}
+
+// WeekBoundary defines when the calender weeks of the group start
+type WeekBoundary struct {
+	// Location is the time zone of the group
+	Location *time.Location
+
+	// CutoffHour is the hour on monday the new week starts at
+	CutoffHour int
+
+	// GracePeriod is the time after the start of a week in which uploads
+	// can still go into the previous week
+	GracePeriod time.Duration
+}
+
+// CWAt returns the calender week the time belongs to
+// Times on monday before the cutoff hour still belong to the previous week.
+func (b WeekBoundary) CWAt(t time.Time) CW {
+	local := t.In(b.Location)
+	if local.Weekday() == time.Monday && local.Hour() < b.CutoffHour {
+		local = local.AddDate(0, 0, -1)
+	}
+	year, week := local.ISOWeek()
+	return CW{Year: year, Week: week}
+}
+
+// Current returns the current calender week
+func (b WeekBoundary) Current() CW {
+	return b.CWAt(time.Now())
+}
+
+// Start returns the time the calender week starts at
+func (b WeekBoundary) Start(cw CW) time.Time {
+	// the 4th of january is always in week 1
+	jan4 := time.Date(cw.Year, time.January, 4, 0, 0, 0, 0, b.Location)
+	monday := 4 - (int(jan4.Weekday())+6)%7
+	return time.Date(cw.Year, time.January, monday+(cw.Week-1)*7, b.CutoffHour, 0, 0, 0, b.Location)
+}
+
+// GraceWeek returns the previous calender week if uploads can still go into it
+func (b WeekBoundary) GraceWeek(now time.Time) *CW {
+	if b.GracePeriod <= 0 {
+		return nil
+	}
+	start := b.Start(b.CWAt(now))
+	if now.Sub(start) >= b.GracePeriod {
+		return nil
+	}
+	previous := b.CWAt(start.AddDate(0, 0, -1))
+	return &previous
+}
+
+// IsScheduled checks if the maimai is not published yet
+func (meta Metadata) IsScheduled(now time.Time) bool {
+	return !meta.PublishAt.IsZero() && now.Before(meta.PublishAt)
+}
+
+// parsePublishTime parses the value of a datetime-local input in the group's time zone
+// an empty value means now
+func parsePublishTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, Weeks.Location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid publish time %q", value)
+}
+
+// checkPublishTime checks that a maimai or template of the calender week is published in that week
+// Times in the current minute count as now, as the form has no seconds.
+// The error message is shown to the user.
+func checkPublishTime(publishAt time.Time, cw CW, now time.Time) error {
+	if publishAt.IsZero() {
+		return nil
+	}
+	if publishAt.Before(now.Truncate(time.Minute)) {
+		return errors.New("Der Zeitpunkt ist schon vorbei")
+	}
+	if !publishAt.Before(Weeks.Start(cw.Next())) {
+		return fmt.Errorf("Der Zeitpunkt muss vor dem Ende von KW %d liegen", cw.Week)
+	}
+	return nil
+}
+
+// schedulePublish announces maimais when they become visible
+// Announcements of a server that is restarted before are scheduled again by schedulePending.
+func schedulePublish(s *Subscriptions, user string, maimais []UserMaimai, at time.Time) {
+	time.AfterFunc(time.Until(at), func() {
+		for _, m := range maimais {
+			Events.Publish(NewMaimaiEvent(EventUpload, m, user))
+		}
+		announceUploads(s, user, len(maimais))
+	})
+}
+
+// schedulePending schedules the announcements of the maimais and templates that are not published yet
+// The timers of schedulePublish don't survive a restart, so this runs on startup.
+// Scheduled maimais are announced together with the ones of the same upload.
+func schedulePending(source MaimaiSource, s *Subscriptions) {
+	type upload struct {
+		user UserName
+		at   int64
+	}
+	current := Weeks.Current()
+	for _, year := range source.GetYears() {
+		// scheduled files can only be in the grace week or later
+		if year < current.Year-1 {
+			continue
+		}
+		cws, err := source.GetCWsOfYear(year)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, cw := range cws {
+			week, err := source.GetMaimaisForCW(cw)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			uploads := map[upload][]UserMaimai{}
+			for _, m := range week.Scheduled {
+				user := m.Meta.Uploader
+				if len(user) == 0 {
+					user = m.User
+				}
+				key := upload{user: user, at: m.Meta.PublishAt.UnixNano()}
+				uploads[key] = append(uploads[key], m)
+			}
+			for key, maimais := range uploads {
+				log.Infof("%d maimais of %s in %s are published at %s", len(maimais), key.user, cw.Path(), maimais[0].Meta.PublishAt)
+				schedulePublish(s, string(key.user), maimais, maimais[0].Meta.PublishAt)
+			}
+			for _, t := range week.ScheduledTemplates {
+				template := t
+				log.Infof("%s in %s is published at %s", template.FileName(), cw.Path(), template.Meta.PublishAt)
+				time.AfterFunc(time.Until(template.Meta.PublishAt), func() {
+					announceTemplate(s, "", template)
+				})
+			}
+		}
+	}
+}
+
+// announceUploads notifies all subscribers about new maimais of the user
+func announceUploads(s *Subscriptions, user string, count int) {
+	switch count {
+	case 0:
+	case 1:
+		s.Send(fmt.Sprintf("%s hat ein Maimai pfostiert", user))
+	default:
+		s.Send(fmt.Sprintf("%s hat %d Maimais pfostiert", user, count))
+	}
+}
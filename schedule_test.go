@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeekBoundary(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	weeks := WeekBoundary{Location: berlin, CutoffHour: 4, GracePeriod: 12 * time.Hour}
+
+	tests := []struct {
+		time time.Time
+		cw   CW
+	}{
+		// monday 00:05 still belongs to the old week
+		{time.Date(2021, 3, 8, 0, 5, 0, 0, berlin), CW{Year: 2021, Week: 9}},
+		{time.Date(2021, 3, 8, 4, 0, 0, 0, berlin), CW{Year: 2021, Week: 10}},
+		// utc is an hour behind
+		{time.Date(2021, 3, 8, 2, 30, 0, 0, time.UTC), CW{Year: 2021, Week: 9}},
+		{time.Date(2021, 3, 8, 3, 30, 0, 0, time.UTC), CW{Year: 2021, Week: 10}},
+		{time.Date(2021, 3, 14, 23, 59, 0, 0, berlin), CW{Year: 2021, Week: 10}},
+	}
+	for _, test := range tests {
+		if cw := weeks.CWAt(test.time); cw != test.cw {
+			t.Errorf("expected %v for %v, got %v", test.cw, test.time, cw)
+		}
+	}
+
+	start := weeks.Start(CW{Year: 2021, Week: 10})
+	if !start.Equal(time.Date(2021, 3, 8, 4, 0, 0, 0, berlin)) {
+		t.Errorf("unexpected start of week 10: %v", start)
+	}
+	// week 1 of 2021 starts on the 4th of january
+	if start := weeks.Start(CW{Year: 2021, Week: 1}); start.Day() != 4 || start.Month() != time.January {
+		t.Errorf("unexpected start of week 1: %v", start)
+	}
+
+	if cw := weeks.GraceWeek(time.Date(2021, 3, 8, 10, 0, 0, 0, berlin)); cw == nil || *cw != (CW{Year: 2021, Week: 9}) {
+		t.Errorf("expected week 9 in grace period, got %v", cw)
+	}
+	if cw := weeks.GraceWeek(time.Date(2021, 3, 8, 16, 0, 0, 0, berlin)); cw != nil {
+		t.Errorf("expected grace period to be over, got %v", cw)
+	}
+}
+
+func TestScheduledUpload(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	handler := uploadHandler(source, &Subscriptions{}, NewURLFetcher(1<<20, time.Second, false), DefaultUploadOptions)
+
+	publishAt := time.Now().Add(time.Hour).In(Weeks.Location).Format("2006-01-02T15:04")
+	req := multipartUploadWithFields(t, map[string]string{"publishAt": publishAt}, testPNG(t))
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected status 303, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	cw := Weeks.Current()
+	week, err := source.GetMaimaisForCW(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(week.Maimais) != 0 || len(week.Scheduled) != 1 {
+		t.Fatalf("expected the maimai to be scheduled, got %d published and %d scheduled", len(week.Maimais), len(week.Scheduled))
+	}
+	if week.NextCounter() != 2 || week.UserUploads("hans") != 1 {
+		t.Error("expected scheduled maimais to be counted")
+	}
+
+	// the previous week can only be chosen in the grace period
+	req = multipartUploadWithFields(t, map[string]string{"week": "previous"}, testPNG(t))
+	resp = httptest.NewRecorder()
+	handler(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 outside of the grace period, got %d", resp.Code)
+	}
+
+	past := time.Now().Add(-time.Hour).In(Weeks.Location).Format("2006-01-02T15:04")
+	req = multipartUploadWithFields(t, map[string]string{"publishAt": past}, testPNG(t))
+	resp = httptest.NewRecorder()
+	handler(resp, req)
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "vorbei") {
+		t.Errorf("expected status 400 for a time in the past, got %d: %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestCheckPublishTime(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 30, 0, Weeks.Location)
+	cw := Weeks.CWAt(now)
+	end := Weeks.Start(cw.Next())
+	tests := []struct {
+		publishAt time.Time
+		cw        CW
+		valid     bool
+	}{
+		{time.Time{}, cw, true},
+		// the form has no seconds
+		{now.Truncate(time.Minute), cw, true},
+		{now.Add(-time.Minute), cw, false},
+		{end.Add(-time.Minute), cw, true},
+		{end, cw, false},
+		// templates of the next week can be published until its end
+		{end, cw.Next(), true},
+		// the grace week is over
+		{now.Add(time.Hour), cw.Prev(), false},
+	}
+	for _, test := range tests {
+		if err := checkPublishTime(test.publishAt, test.cw, now); (err == nil) != test.valid {
+			t.Errorf("expected %v in %s to be valid: %v, got %v", test.publishAt, test.cw.Path(), test.valid, err)
+		}
+	}
+}
+
+func TestMaimaiFileServer(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	cw := Weeks.Current()
+	writeTestMaimais(t, source,
+		filepath.Join(cw.Path(), "1_hans_1.png"),
+		filepath.Join(cw.Path(), "2_fritz_1.png"),
+		filepath.Join(cw.Path(), "template_1.png"),
+		filepath.Join(cw.Path(), posterFolder, "3_hans_2.jpg"),
+		filepath.Join(cw.Path(), ".template.tmp"),
+		"users/hans.png",
+	)
+	later := time.Now().Add(time.Hour)
+	err := source.UpdateWeekMetadata(cw, func(meta WeekMetadata) error {
+		meta["2_fritz_1.png"] = Metadata{PublishAt: later}
+		meta["template_1.png"] = Metadata{PublishAt: later}
+		meta["3_hans_2.mp4"] = Metadata{PublishAt: later}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := http.StripPrefix("/mm/", maimaiFileServer(source))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{cw.Path() + "/1_hans_1.png", http.StatusOK},
+		{"users/hans.png", http.StatusOK},
+		{cw.Path() + "/2_fritz_1.png", http.StatusNotFound},
+		{cw.Path() + "/template_1.png", http.StatusNotFound},
+		{cw.Path() + "/" + posterFolder + "/3_hans_2.jpg", http.StatusNotFound},
+		{cw.Path() + "/" + metadataFile, http.StatusNotFound},
+		{cw.Path() + "/.template.tmp", http.StatusNotFound},
+		{cw.Path() + "/", http.StatusNotFound},
+		{"", http.StatusNotFound},
+		{cw.Path() + "/../" + filepath.Base(cw.Path()) + "/2_fritz_1.png", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/mm/"+test.path, nil))
+		if resp.Code != test.status {
+			t.Errorf("expected status %d for %s, got %d", test.status, test.path, resp.Code)
+		}
+	}
+}
+
+func TestSchedulePending(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	cw := Weeks.Current()
+	writeTestMaimais(t, source, filepath.Join(cw.Path(), "1_hans_1.png"), filepath.Join(cw.Path(), "2_hans_2.png"), filepath.Join(cw.Path(), "template_1.png"))
+	soon := time.Now().Add(50 * time.Millisecond)
+	err := source.UpdateWeekMetadata(cw, func(meta WeekMetadata) error {
+		meta["1_hans_1.png"] = Metadata{Uploader: "hans", PublishAt: soon}
+		meta["2_hans_2.png"] = Metadata{Uploader: "hans", PublishAt: soon}
+		meta["template_1.png"] = Metadata{PublishAt: soon}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := Events.Subscribe()
+	defer unsubscribe()
+	schedulePending(source, &Subscriptions{})
+	received := map[string]int{}
+	timeout := time.After(5 * time.Second)
+	for received[EventUpload] < 2 || received[EventTemplate] < 1 {
+		select {
+		case e := <-events:
+			if e.CW == cw {
+				received[e.Type]++
+			}
+		case <-timeout:
+			t.Fatalf("expected the pending uploads and template to be announced, got %v", received)
+		}
+	}
+}
+
+func TestISOYearBoundary(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	weeks := WeekBoundary{Location: berlin}
+
+	tests := []struct {
+		time time.Time
+		cw   CW
+	}{
+		// 2020 has 53 weeks
+		{time.Date(2020, 12, 31, 12, 0, 0, 0, berlin), CW{Year: 2020, Week: 53}},
+		{time.Date(2021, 1, 3, 23, 59, 0, 0, berlin), CW{Year: 2020, Week: 53}},
+		{time.Date(2021, 1, 4, 0, 0, 0, 0, berlin), CW{Year: 2021, Week: 1}},
+		// week 1 of 2020 and 2025 starts in december
+		{time.Date(2019, 12, 30, 0, 0, 0, 0, berlin), CW{Year: 2020, Week: 1}},
+		{time.Date(2024, 12, 30, 8, 0, 0, 0, berlin), CW{Year: 2025, Week: 1}},
+		{time.Date(2021, 12, 31, 12, 0, 0, 0, berlin), CW{Year: 2021, Week: 52}},
+		// sunday evening in utc is monday in berlin
+		{time.Date(2019, 12, 29, 23, 30, 0, 0, time.UTC), CW{Year: 2020, Week: 1}},
+		{time.Date(2021, 1, 3, 23, 30, 0, 0, time.UTC), CW{Year: 2021, Week: 1}},
+	}
+	for _, test := range tests {
+		if cw := weeks.CWAt(test.time); cw != test.cw {
+			t.Errorf("expected %v for %v, got %v", test.cw, test.time, cw)
+		}
+	}
+
+	for _, cw := range []CW{{Year: 2020, Week: 1}, {Year: 2020, Week: 53}, {Year: 2021, Week: 1}, {Year: 2025, Week: 1}} {
+		if got := weeks.CWAt(weeks.Start(cw)); got != cw {
+			t.Errorf("expected start of %v to be in the same week, got %v", cw, got)
+		}
+		if got := weeks.CWAt(weeks.Start(cw).Add(-time.Minute)); got == cw {
+			t.Errorf("expected the minute before the start of %v to be in the previous week", cw)
+		}
+	}
+}
+
+func TestDaylightSavingTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	old := Weeks
+	defer func() { Weeks = old }()
+	Weeks = WeekBoundary{Location: berlin, CutoffHour: 3}
+
+	// clocks go forward on sunday 28th of march 2021 at 2:00
+	if s := formatTime(time.Date(2021, 3, 28, 0, 30, 0, 0, time.UTC)); s != "So 01:30" {
+		t.Errorf("expected So 01:30 before the switch, got %s", s)
+	}
+	if s := formatTime(time.Date(2021, 3, 28, 1, 30, 0, 0, time.UTC)); s != "So 03:30" {
+		t.Errorf("expected So 03:30 after the switch, got %s", s)
+	}
+	// clocks go back on sunday 31st of october 2021 at 3:00
+	if s := formatTime(time.Date(2021, 10, 31, 0, 30, 0, 0, time.UTC)); s != "So 02:30" {
+		t.Errorf("expected So 02:30 before the switch, got %s", s)
+	}
+	if s := formatTime(time.Date(2021, 10, 31, 1, 30, 0, 0, time.UTC)); s != "So 02:30" {
+		t.Errorf("expected So 02:30 after the switch, got %s", s)
+	}
+
+	// the week after a switch starts at the cutoff hour of local time
+	for _, cw := range []CW{{Year: 2021, Week: 13}, {Year: 2021, Week: 44}} {
+		start := Weeks.Start(cw).In(berlin)
+		if start.Weekday() != time.Monday || start.Hour() != 3 {
+			t.Errorf("expected %v to start on monday at 3:00, got %v", cw, start)
+		}
+	}
+	// 1:30 utc on monday is 3:30 in summer and 2:30 in winter
+	if cw := Weeks.CWAt(time.Date(2021, 3, 29, 1, 30, 0, 0, time.UTC)); cw.Week != 13 {
+		t.Errorf("expected week 13 after the cutoff in summer time, got %v", cw)
+	}
+	if cw := Weeks.CWAt(time.Date(2021, 11, 1, 1, 30, 0, 0, time.UTC)); cw.Week != 43 {
+		t.Errorf("expected week 43 before the cutoff in winter time, got %v", cw)
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// maxSearchResults limits the number of maimais returned by a search
+const maxSearchResults = 100
+
+// Weights of the fields of a maimai in the search ranking
+const (
+	weightUser    = 3
+	weightTag     = 3
+	weightCaption = 3
+	weightAltText = 2
+	weightComment = 1
+	weightOCR     = 1
+)
+
+// SearchDocument holds the searchable texts of a published maimai
+type SearchDocument struct {
+	CW         CW        `json:"cw"`
+	Counter    int       `json:"counter"`
+	User       UserName  `json:"user"`
+	UploadTime time.Time `json:"uploadTime"`
+	Caption    string    `json:"caption,omitempty"`
+	AltText    string    `json:"alt,omitempty"`
+	Comments   []string  `json:"comments,omitempty"`
+	OCRText    string    `json:"ocr,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+}
+
+// NewSearchDocument collects the searchable texts of the maimai
+func NewSearchDocument(m UserMaimai) SearchDocument {
+	doc := SearchDocument{
+		CW:         m.CW,
+		Counter:    m.Counter,
+		User:       m.User,
+		UploadTime: m.UploadTime,
+		Caption:    m.Meta.Caption,
+		AltText:    m.Meta.AltText,
+		OCRText:    m.Meta.OCRText,
+		Tags:       m.Meta.Tags,
+	}
+	for _, c := range m.Meta.Comments {
+		doc.Comments = append(doc.Comments, c.Text)
+	}
+	return doc
+}
+
+// Permalink returns the url of the maimai's detail page
+func (d SearchDocument) Permalink() string {
+	return UserMaimai{CW: d.CW, Counter: d.Counter}.Permalink()
+}
+
+// terms returns the weighted terms of the document
+func (d SearchDocument) terms() map[string]int {
+	terms := map[string]int{}
+	add := func(text string, weight int) {
+		for _, term := range tokenize(text) {
+			if terms[term] < weight {
+				terms[term] = weight
+			}
+		}
+	}
+	add(string(d.User), weightUser)
+	add(d.Caption, weightCaption)
+	add(d.AltText, weightAltText)
+	for _, c := range d.Comments {
+		add(c, weightComment)
+	}
+	add(d.OCRText, weightOCR)
+	for _, tag := range d.Tags {
+		add(tag, weightTag)
+	}
+	return terms
+}
+
+// tokenize splits the text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchQuery filters the maimais of the index
+// Zero values do not filter.
+type SearchQuery struct {
+	Text string
+	User string
+	Year int
+	// Tag only matches maimais with exactly this tag
+	Tag string
+	// FromWeek and ToWeek limit the calender weeks, in every year if no year is set
+	FromWeek int
+	ToWeek   int
+}
+
+// matches checks if the document passes the filters of the query
+func (q SearchQuery) matches(d SearchDocument) bool {
+	return (len(q.User) == 0 || strings.EqualFold(string(d.User), q.User)) &&
+		(q.Year == 0 || d.CW.Year == q.Year) &&
+		(len(q.Tag) == 0 || contains(d.Tags, q.Tag)) &&
+		(q.FromWeek == 0 || d.CW.Week >= q.FromWeek) &&
+		(q.ToWeek == 0 || d.CW.Week <= q.ToWeek)
+}
+
+// SearchIndex is an inverted index over the texts of all published maimais
+// It is kept in memory and saved as a JSON file.
+type SearchIndex struct {
+	lock sync.RWMutex
+	path string
+	// documents are keyed by their permalink
+	documents map[string]SearchDocument
+	// terms maps each term to the weights of the documents it is found in
+	terms map[string]map[string]int
+	// saved is when the file the index was opened from was written
+	saved time.Time
+}
+
+// OpenSearchIndex loads the index saved at path
+// An empty index is returned together with the error if it cannot be read,
+// it needs to be rebuilt from the maimai directory.
+func OpenSearchIndex(path string) (*SearchIndex, error) {
+	index := &SearchIndex{
+		path:      path,
+		documents: map[string]SearchDocument{},
+		terms:     map[string]map[string]int{},
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return index, err
+	}
+	index.saved = info.ModTime()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return index, err
+	}
+	var documents []SearchDocument
+	if err := json.Unmarshal(data, &documents); err != nil {
+		return index, err
+	}
+	for _, doc := range documents {
+		index.add(doc)
+	}
+	return index, nil
+}
+
+// Len returns the number of indexed maimais
+func (index *SearchIndex) Len() int {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+	return len(index.documents)
+}
+
+func (index *SearchIndex) add(doc SearchDocument) {
+	key := doc.Permalink()
+	index.remove(key)
+	index.documents[key] = doc
+	for term, weight := range doc.terms() {
+		if index.terms[term] == nil {
+			index.terms[term] = map[string]int{}
+		}
+		index.terms[term][key] = weight
+	}
+}
+
+func (index *SearchIndex) remove(key string) {
+	doc, ok := index.documents[key]
+	if !ok {
+		return
+	}
+	for term := range doc.terms() {
+		delete(index.terms[term], key)
+		if len(index.terms[term]) == 0 {
+			delete(index.terms, term)
+		}
+	}
+	delete(index.documents, key)
+}
+
+// save writes the index to its file, the lock must be held
+func (index *SearchIndex) save() error {
+	if len(index.path) == 0 {
+		return nil
+	}
+	documents := make([]SearchDocument, 0, len(index.documents))
+	for _, doc := range index.documents {
+		documents = append(documents, doc)
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].Permalink() < documents[j].Permalink()
+	})
+	data, err := json.Marshal(documents)
+	if err != nil {
+		return err
+	}
+	tmp := index.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, index.path)
+}
+
+// Rebuild indexes all published maimais of the directory again
+func (index *SearchIndex) Rebuild(source MaimaiSource) error {
+	documents := []SearchDocument{}
+	for _, year := range source.GetYears() {
+		cws, err := source.GetCWsOfYear(year)
+		if err != nil {
+			return err
+		}
+		for _, cw := range cws {
+			week, err := source.GetMaimaisForCW(cw)
+			if err != nil {
+				return err
+			}
+			for _, m := range week.Maimais {
+				documents = append(documents, NewSearchDocument(m))
+			}
+		}
+	}
+
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.documents = map[string]SearchDocument{}
+	index.terms = map[string]map[string]int{}
+	for _, doc := range documents {
+		index.add(doc)
+	}
+	return index.save()
+}
+
+// Refresh indexes the weeks again that changed since the index was saved
+// and the weeks with maimais that were published since then.
+// It catches up with changes while the server was not running.
+func (index *SearchIndex) Refresh(source MaimaiSource) error {
+	now := time.Now()
+	for _, year := range source.GetYears() {
+		cws, err := source.GetCWsOfYear(year)
+		if err != nil {
+			return err
+		}
+		for _, cw := range cws {
+			modTime, err := weekModTime(source, cw)
+			if err != nil {
+				return err
+			}
+			changed := modTime.After(index.saved)
+			if !changed {
+				meta, err := source.ReadMetadata(cw)
+				if err != nil {
+					return err
+				}
+				for _, m := range meta {
+					if m.PublishAt.After(index.saved) && !m.IsScheduled(now) {
+						changed = true
+						break
+					}
+				}
+			}
+			if !changed {
+				continue
+			}
+			log.Infof("updating search index for %s", cw.Path())
+			if err := index.UpdateWeek(source, cw); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateWeek indexes the published maimais of the calender week again
+func (index *SearchIndex) UpdateWeek(source MaimaiSource, cw CW) error {
+	week, err := source.GetMaimaisForCW(cw)
+	if err != nil {
+		return err
+	}
+
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	for key, doc := range index.documents {
+		if doc.CW == cw {
+			index.remove(key)
+		}
+	}
+	for _, m := range week.Maimais {
+		index.add(NewSearchDocument(m))
+	}
+	return index.save()
+}
+
+// Watch updates the index for every change published on the event bus
+// It never returns and should run in its own goroutine.
+func (index *SearchIndex) Watch(source MaimaiSource, bus *EventBus) {
+	events, _ := bus.Subscribe()
+
+	// weeks are collected while the index is updated,
+	// so the events of bulk uploads do not pile up in the subscription
+	var lock sync.Mutex
+	pending := map[CW]bool{}
+	wake := make(chan struct{}, 1)
+	go func() {
+		for range wake {
+			lock.Lock()
+			weeks := pending
+			pending = map[CW]bool{}
+			lock.Unlock()
+			for cw := range weeks {
+				if err := index.UpdateWeek(source, cw); err != nil {
+					log.Errorf("cannot update search index for %s: %v", cw.Path(), err)
+				}
+			}
+		}
+	}()
+
+	for e := range events {
+		switch e.Type {
+		case EventUpload, EventDelete, EventCaption, EventComment, EventOCR, EventTag:
+		default:
+			continue
+		}
+		lock.Lock()
+		pending[e.CW] = true
+		lock.Unlock()
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	close(wake)
+}
+
+// Search returns the documents that contain all words of the query text, best matches first
+// Words match all terms they are a prefix of. Without text all documents passing the filters are returned, newest first.
+func (index *SearchIndex) Search(query SearchQuery) []SearchDocument {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	var scores map[string]int
+	words := tokenize(query.Text)
+	if len(words) == 0 {
+		scores = map[string]int{}
+		for key := range index.documents {
+			scores[key] = 0
+		}
+	}
+	for _, word := range words {
+		wordScores := map[string]int{}
+		for term, docs := range index.terms {
+			if !strings.HasPrefix(term, word) {
+				continue
+			}
+			for key, weight := range docs {
+				if wordScores[key] < weight {
+					wordScores[key] = weight
+				}
+			}
+		}
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		for key, score := range scores {
+			if weight, ok := wordScores[key]; ok {
+				scores[key] = score + weight
+			} else {
+				delete(scores, key)
+			}
+		}
+	}
+
+	results := []SearchDocument{}
+	for key := range scores {
+		if doc := index.documents[key]; query.matches(doc) {
+			results = append(results, doc)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if sa, sb := scores[a.Permalink()], scores[b.Permalink()]; sa != sb {
+			return sa > sb
+		}
+		return b.UploadTime.Before(a.UploadTime)
+	})
+	return results
+}
+
+// TagCount is a tag and the number of maimais with it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Tags returns all tags of the indexed maimais, most used first
+func (index *SearchIndex) Tags() []TagCount {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+	counts := map[string]int{}
+	for _, doc := range index.documents {
+		for _, tag := range doc.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags
+}
+
+// loadMaimais reads the maimais of the documents from the directory
+// Documents of maimais that do not exist anymore are skipped, the index may be behind the files.
+func loadMaimais(source MaimaiSource, documents []SearchDocument) []UserMaimai {
+	weeks := map[CW]*Week{}
+	maimais := []UserMaimai{}
+	for _, doc := range documents {
+		week, ok := weeks[doc.CW]
+		if !ok {
+			var err error
+			week, err = source.GetMaimaisForCW(doc.CW)
+			if err != nil {
+				log.Error(err)
+			}
+			weeks[doc.CW] = week
+		}
+		if week == nil {
+			continue
+		}
+		if m := week.Maimai(doc.Counter); m != nil {
+			maimais = append(maimais, *m)
+		}
+	}
+	return maimais
+}
+
+// parseSearchQuery reads the query from the url parameters q, user, year, from and to
+func parseSearchQuery(r *http.Request) (SearchQuery, error) {
+	values := r.URL.Query()
+	query := SearchQuery{
+		Text: strings.TrimSpace(values.Get("q")),
+		User: strings.ToLower(strings.TrimSpace(values.Get("user"))),
+		Tag:  normalizeTag(values.Get("tag")),
+	}
+	for name, field := range map[string]*int{"year": &query.Year, "from": &query.FromWeek, "to": &query.ToWeek} {
+		value := values.Get(name)
+		if len(value) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return query, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*field = n
+	}
+	return query, nil
+}
+
+// searchHandler searches the maimais of all years
+func searchHandler(template template.Template, source MaimaiSource, index *SearchIndex, users []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseSearchQuery(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		searching := query != SearchQuery{}
+		documents := []SearchDocument{}
+		if searching {
+			documents = index.Search(query)
+		}
+		if len(documents) > maxSearchResults {
+			documents = documents[:maxSearchResults]
+		}
+
+		if wantsJSON(r) {
+			type result struct {
+				URL     string   `json:"url"`
+				Year    int      `json:"year"`
+				Week    int      `json:"week"`
+				User    UserName `json:"user"`
+				Caption string   `json:"caption,omitempty"`
+			}
+			results := make([]result, len(documents))
+			for i, doc := range documents {
+				results[i] = result{
+					URL:     doc.Permalink(),
+					Year:    doc.CW.Year,
+					Week:    doc.CW.Week,
+					User:    doc.User,
+					Caption: doc.Caption,
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(results); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
+		maimais := loadMaimais(source, documents)
+		err = template.Execute(w, struct {
+			Query     SearchQuery
+			Searching bool
+			Maimais   []UserMaimai
+			Users     []string
+			Years     []int
+		}{
+			Query:     query,
+			Searching: searching,
+			Maimais:   maimais,
+			Users:     users,
+			Years:     source.GetYears(),
+		})
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// searchIndexPath returns the default location of the search index in the data directory
+func searchIndexPath(dataDir string) string {
+	return filepath.Join(dataDir, "search.json")
+}
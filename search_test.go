@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// searchSource creates a directory with three maimais in two years
+func searchSource(t *testing.T) MaimaiSource {
+	source := MaimaiSource(t.TempDir())
+	maimais := []struct {
+		cw       CW
+		fileName string
+		meta     Metadata
+	}{
+		{CW{Year: 2020, Week: 53}, "1_hans_1.png", Metadata{UploadTime: time.Date(2020, 12, 30, 12, 0, 0, 0, time.UTC), Caption: "Frühling im Lockdown", AltText: "Eine Taube"}},
+		{CW{Year: 2021, Week: 12}, "1_fritz_1.png", Metadata{UploadTime: time.Date(2021, 3, 25, 12, 0, 0, 0, time.UTC), Caption: "Impfung", Comments: []Comment{{ID: 1, Author: "hans", Text: "Taubenalarm!"}}}},
+		{CW{Year: 2021, Week: 14}, "1_hans_1.png", Metadata{UploadTime: time.Date(2021, 4, 8, 12, 0, 0, 0, time.UTC), Caption: "Ostern"}},
+	}
+	for _, m := range maimais {
+		if err := os.MkdirAll(filepath.Join(string(source), m.cw.Path()), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(string(source), m.cw.Path(), m.fileName), testPNG(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+		meta := m.meta
+		if err := source.UpdateMetadata(m.cw, m.fileName, func(mm *Metadata) { *mm = meta }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+func TestSearchIndex(t *testing.T) {
+	source := searchSource(t)
+	path := filepath.Join(t.TempDir(), "search.json")
+	index, err := OpenSearchIndex(path)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected missing index, got %v", err)
+	}
+	if err := index.Rebuild(source); err != nil {
+		t.Fatal(err)
+	}
+	if index.Len() != 3 {
+		t.Fatalf("expected 3 indexed maimais, got %d", index.Len())
+	}
+
+	tests := []struct {
+		query    SearchQuery
+		expected []string
+	}{
+		// comments and alt texts are searched, the alt text ranks higher
+		{SearchQuery{Text: "taube"}, []string{"/2020/CW_53/1", "/2021/CW_12/1"}},
+		{SearchQuery{Text: "FRÜHLING lock"}, []string{"/2020/CW_53/1"}},
+		{SearchQuery{Text: "frühling impfung"}, []string{}},
+		{SearchQuery{Text: "hans"}, []string{"/2021/CW_14/1", "/2020/CW_53/1"}},
+		{SearchQuery{Text: "taube", User: "Fritz"}, []string{"/2021/CW_12/1"}},
+		{SearchQuery{Year: 2021}, []string{"/2021/CW_14/1", "/2021/CW_12/1"}},
+		{SearchQuery{FromWeek: 13, ToWeek: 53}, []string{"/2021/CW_14/1", "/2020/CW_53/1"}},
+		{SearchQuery{Year: 2021, ToWeek: 13}, []string{"/2021/CW_12/1"}},
+	}
+	for _, test := range tests {
+		results := index.Search(test.query)
+		found := make([]string, len(results))
+		for i, doc := range results {
+			found[i] = doc.Permalink()
+		}
+		if strings.Join(found, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("expected %v for %+v, got %v", test.expected, test.query, found)
+		}
+	}
+
+	// the saved index can be opened again
+	reopened, err := OpenSearchIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 3 || len(reopened.Search(SearchQuery{Text: "ostern"})) != 1 {
+		t.Error("expected the reopened index to contain all maimais")
+	}
+}
+
+func TestSearchIndexRefresh(t *testing.T) {
+	source := searchSource(t)
+	path := filepath.Join(t.TempDir(), "search.json")
+	index, _ := OpenSearchIndex(path)
+	if err := index.Rebuild(source); err != nil {
+		t.Fatal(err)
+	}
+
+	// a maimai that was published while the server was not running
+	cw := CW{Year: 2021, Week: 12}
+	writeTestMaimais(t, source, filepath.Join(cw.Path(), "2_hans_1.png"))
+	err := source.UpdateMetadata(cw, "2_hans_1.png", func(m *Metadata) {
+		m.Caption = "Verschoben"
+		m.PublishAt = time.Now().Add(-30 * time.Minute)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the index was saved after the upload, but before the publish time
+	earlier, saved := time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)
+	for _, week := range []string{"2020/CW_53", "2021/CW_12", "2021/CW_14"} {
+		for _, p := range []string{filepath.Join(string(source), week), filepath.Join(string(source), week, metadataFile)} {
+			if err := os.Chtimes(p, earlier, earlier); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.Chtimes(path, saved, saved); err != nil {
+		t.Fatal(err)
+	}
+	// a caption that was changed on disk
+	err = source.UpdateMetadata(CW{Year: 2021, Week: 14}, "1_hans_1.png", func(m *Metadata) {
+		m.Caption = "Pfingsten"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenSearchIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Refresh(source); err != nil {
+		t.Fatal(err)
+	}
+	for query, expected := range map[string]int{"verschoben": 1, "pfingsten": 1, "ostern": 0, "lockdown": 1} {
+		if results := reopened.Search(SearchQuery{Text: query}); len(results) != expected {
+			t.Errorf("expected %d results for %q, got %d", expected, query, len(results))
+		}
+	}
+}
+
+func TestSearchIndexUpdate(t *testing.T) {
+	source := searchSource(t)
+	index, _ := OpenSearchIndex("")
+	if err := index.Rebuild(source); err != nil {
+		t.Fatal(err)
+	}
+	cw := CW{Year: 2021, Week: 14}
+	err := source.UpdateMetadata(cw, "1_hans_1.png", func(meta *Metadata) {
+		meta.Caption = "Eiersuche"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := NewEventBus()
+	go index.Watch(source, bus)
+	for bus.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	bus.Publish(Event{Type: EventCaption, CW: cw, Counter: 1})
+	for i := 0; i < 100 && len(index.Search(SearchQuery{Text: "eiersuche"})) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(index.Search(SearchQuery{Text: "eiersuche"})) != 1 || len(index.Search(SearchQuery{Text: "ostern"})) != 0 {
+		t.Error("expected the index to be updated after the event")
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	source := searchSource(t)
+	index, _ := OpenSearchIndex("")
+	if err := index.Rebuild(source); err != nil {
+		t.Fatal(err)
+	}
+	handler := searchHandler(*loadTemplates("templates").Lookup("search.html"), source, index, []string{"hans", "fritz"})
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=taube&year=2021", nil)
+	req.Header.Set("Accept", "application/json")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	var results []struct {
+		URL  string
+		User string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].URL != "/2021/CW_12/1" || results[0].User != "fritz" {
+		t.Errorf("expected the maimai of fritz, got %+v", results)
+	}
+
+	resp = httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(http.MethodGet, "/search?q=ostern", nil))
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `href="/2021/CW_14/1"`) {
+		t.Errorf("expected a card for the result, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(http.MethodGet, "/search?year=abc", nil))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid year, got %d", resp.Code)
+	}
+}
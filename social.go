@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// SocialMeta holds the information for Open Graph and Twitter Card meta tags
+type SocialMeta struct {
+	Title       string
+	Description string
+	// URL is the absolute url of the page
+	URL string
+	// Image is the absolute url of a representative image, may be empty
+	Image    string
+	ImageAlt string
+	// Video is the absolute url of the video if the page shows one
+	Video     string
+	VideoType string
+}
+
+// newSocialMeta creates the meta information for a page
+// thumbnail is used as the representative image and may be nil
+func newSocialMeta(r *http.Request, title, description string, thumbnail Maimai) SocialMeta {
+	base := baseURL(r)
+	meta := SocialMeta{
+		Title:       title,
+		Description: description,
+		URL:         base + r.URL.Path,
+	}
+	if thumbnail != nil {
+		meta.Image = fmt.Sprintf("%s/mm/%s", base, thumbnail.Href())
+		meta.ImageAlt = thumbnail.FileName()
+		if m, ok := thumbnail.(UserMaimai); ok {
+			meta.ImageAlt = m.Alt()
+			if m.IsVideo() {
+				meta.Video, meta.VideoType = meta.Image, mimeTypeForExtension(m.ImageType)
+				meta.Image = fmt.Sprintf("%s/mm/%s", base, m.PosterHref())
+			}
+		}
+	}
+	return meta
+}
+
+// OEmbedURL returns the url of the oEmbed endpoint for the page
+// returns an empty string for pages that cannot be embedded
+func (s SocialMeta) OEmbedURL() string {
+	u, err := url.Parse(s.URL)
+	if err != nil || !(oembedMaimaiPath.MatchString(u.Path) || oembedWeekPath.MatchString(u.Path)) {
+		return ""
+	}
+	return fmt.Sprintf("%s/oembed?format=json&url=%s", s.baseURL(), url.QueryEscape(s.URL))
+}
+
+func (s SocialMeta) baseURL() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+}
+
+// OEmbed is an oEmbed response as specified on https://oembed.com
+type OEmbed struct {
+	Type            string `json:"type"`
+	Version         string `json:"version"`
+	Title           string `json:"title,omitempty"`
+	AuthorName      string `json:"author_name,omitempty"`
+	AuthorURL       string `json:"author_url,omitempty"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	URL             string `json:"url,omitempty"`
+	Width           int    `json:"width,omitempty"`
+	Height          int    `json:"height,omitempty"`
+	HTML            string `json:"html,omitempty"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+}
+
+var (
+	oembedMaimaiPath = regexp.MustCompile(`^/(\d{4})/CW_(\d+)/(\d+)/?$`)
+	oembedWeekPath   = regexp.MustCompile(`^/(\d{4})/CW_(\d+)/?$`)
+)
+
+// oembed returns oEmbed JSON for maimai and week urls
+// maimais are embedded as photos, weeks as links with the week's thumbnail
+func oembed(source MaimaiSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if format := r.URL.Query().Get("format"); len(format) > 0 && format != "json" {
+			w.WriteHeader(http.StatusNotImplemented)
+			fmt.Fprint(w, "nur JSON hier")
+			return
+		}
+		target, err := url.Parse(r.URL.Query().Get("url"))
+		if err != nil || !target.IsAbs() {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		// only pages of this site can be embedded
+		if target.Host != r.Host {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		maxWidth, maxHeight, err := oembedMaxSize(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+
+		base := baseURL(r)
+		response := OEmbed{
+			Version:      "1.0",
+			ProviderName: "MMOTCW",
+			ProviderURL:  base,
+		}
+
+		var cw CW
+		counter := -1
+		if m := oembedMaimaiPath.FindStringSubmatch(target.Path); m != nil {
+			cw.Year, _ = strconv.Atoi(m[1])
+			cw.Week, _ = strconv.Atoi(m[2])
+			counter, _ = strconv.Atoi(m[3])
+		} else if m := oembedWeekPath.FindStringSubmatch(target.Path); m != nil {
+			cw.Year, _ = strconv.Atoi(m[1])
+			cw.Week, _ = strconv.Atoi(m[2])
+		} else {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		week, err := source.GetMaimaisForCW(cw)
+		if err != nil {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		if counter >= 0 {
+			maimai := week.Maimai(counter)
+			if maimai == nil {
+				httpError(w, http.StatusNotFound)
+				return
+			}
+			meta := maimai.Meta
+			if meta.Width == 0 {
+				if err := meta.FillFileInfo(source.FilePath(maimai)); err != nil {
+					log.Warnf("cannot read dimensions of %s: %v", maimai.Href(), err)
+				}
+			}
+			response.Type = "photo"
+			response.Title = maimai.Title()
+			response.AuthorName = string(maimai.User)
+			response.AuthorURL = fmt.Sprintf("%s/%d/%s", base, cw.Year, maimai.User)
+			response.URL = fmt.Sprintf("%s/mm/%s", base, maimai.Href())
+			response.Width, response.Height = fitSize(meta.Width, meta.Height, maxWidth, maxHeight)
+			if maimai.IsVideo() {
+				// the video type has no url but embeds html
+				response.Type = "video"
+				response.HTML = fmt.Sprintf(`<video src="%s" poster="%s/mm/%s" width="%d" height="%d" controls loop playsinline></video>`,
+					html.EscapeString(response.URL), base, maimai.PosterHref(), response.Width, response.Height)
+				response.ThumbnailURL = fmt.Sprintf("%s/mm/%s", base, maimai.PosterHref())
+				response.ThumbnailWidth, response.ThumbnailHeight = response.Width, response.Height
+				response.URL = ""
+			}
+		} else {
+			response.Type = "link"
+			response.Title = fmt.Sprintf("CW %d %d", cw.Week, cw.Year)
+			if thumbnail := week.Thumbnail(); thumbnail != nil {
+				response.ThumbnailURL = fmt.Sprintf("%s/mm/%s", base, thumbnail.Href())
+				if preview, err := thumbnail.Preview(); err == nil {
+					response.ThumbnailWidth, response.ThumbnailHeight = fitSize(preview.Size.X, preview.Size.Y, maxWidth, maxHeight)
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// oembedMaxSize reads the optional query parameters maxwidth and maxheight, 0 means no limit
+func oembedMaxSize(r *http.Request) (maxWidth int, maxHeight int, err error) {
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"maxwidth", &maxWidth}, {"maxheight", &maxHeight}} {
+		v := r.URL.Query().Get(p.name)
+		if len(v) == 0 {
+			continue
+		}
+		if *p.value, err = strconv.Atoi(v); err != nil || *p.value <= 0 {
+			return 0, 0, fmt.Errorf("invalid %s %q", p.name, v)
+		}
+	}
+	return maxWidth, maxHeight, nil
+}
+
+// fitSize scales width and height down to fit into maxWidth and maxHeight and keeps the aspect ratio
+// a maximum of 0 is no limit
+func fitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	if maxWidth > 0 && width > maxWidth {
+		width, height = maxWidth, height*maxWidth/width
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width, height = width*maxHeight/height, maxHeight
+	}
+	return width, height
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOEmbed(t *testing.T) {
+	source := searchSource(t)
+	handler := oembed(source)
+	tests := []struct {
+		query  string
+		status int
+		typ    string
+		width  int
+		height int
+	}{
+		{"url=" + url.QueryEscape("http://example.com/2020/CW_53/1"), http.StatusOK, "photo", 20, 10},
+		{"format=json&maxwidth=10&url=" + url.QueryEscape("http://example.com/2020/CW_53/1/"), http.StatusOK, "photo", 10, 5},
+		{"maxwidth=100&maxheight=4&url=" + url.QueryEscape("http://example.com/2020/CW_53/1"), http.StatusOK, "photo", 8, 4},
+		{"url=" + url.QueryEscape("http://example.com/2021/CW_12"), http.StatusOK, "link", 0, 0},
+		{"maxwidth=klein&url=" + url.QueryEscape("http://example.com/2020/CW_53/1"), http.StatusBadRequest, "", 0, 0},
+		{"maxwidth=0&url=" + url.QueryEscape("http://example.com/2020/CW_53/1"), http.StatusBadRequest, "", 0, 0},
+		{"url=" + url.QueryEscape("/2020/CW_53/1"), http.StatusBadRequest, "", 0, 0},
+		{"", http.StatusBadRequest, "", 0, 0},
+		{"url=" + url.QueryEscape("http://evil.com/2020/CW_53/1"), http.StatusNotFound, "", 0, 0},
+		{"url=" + url.QueryEscape("http://example.com/2020/CW_53/9"), http.StatusNotFound, "", 0, 0},
+		{"url=" + url.QueryEscape("http://example.com/2020/CW_52/1"), http.StatusNotFound, "", 0, 0},
+		{"url=" + url.QueryEscape("http://example.com/search"), http.StatusNotFound, "", 0, 0},
+		{"format=xml&url=" + url.QueryEscape("http://example.com/2020/CW_53/1"), http.StatusNotImplemented, "", 0, 0},
+	}
+	for _, test := range tests {
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest(http.MethodGet, "/oembed?"+test.query, nil))
+		if resp.Code != test.status {
+			t.Errorf("expected status %d for %s, got %d", test.status, test.query, resp.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var response OEmbed
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Type != test.typ || response.Width != test.width || response.Height != test.height {
+			t.Errorf("expected %s of %dx%d for %s, got %+v", test.typ, test.width, test.height, test.query, response)
+		}
+		if response.Type == "photo" && (response.URL != "http://example.com/mm/2020/CW_53/1_hans_1.png" || response.AuthorURL != "http://example.com/2020/hans") {
+			t.Errorf("unexpected urls in %+v", response)
+		}
+	}
+}
+
+func TestSocialMeta(t *testing.T) {
+	cw := CW{Year: 2021, Week: 5}
+	video := UserMaimai{User: "hans", Counter: 3, UserCounter: 1, ImageType: "mp4", CW: cw, Meta: Metadata{AltText: "Eine tanzende Taube"}}
+	meta := newSocialMeta(httptest.NewRequest(http.MethodGet, "/2021/CW_05/3", nil), video.Title(), video.Description(), video)
+	if meta.URL != "http://example.com/2021/CW_05/3" || meta.ImageAlt != "Eine tanzende Taube" {
+		t.Errorf("unexpected url or alt text %+v", meta)
+	}
+	if meta.Video != "http://example.com/mm/2021/CW_05/3_hans_1.mp4" || meta.VideoType != "video/mp4" ||
+		meta.Image != "http://example.com/mm/2021/CW_05/posters/3_hans_1.jpg" {
+		t.Errorf("expected the video with its poster frame, got %+v", meta)
+	}
+	if meta.OEmbedURL() != "http://example.com/oembed?format=json&url="+url.QueryEscape(meta.URL) {
+		t.Errorf("unexpected oEmbed url %s", meta.OEmbedURL())
+	}
+	if search := newSocialMeta(httptest.NewRequest(http.MethodGet, "/search", nil), "Suche", "", nil); search.OEmbedURL() != "" || search.Image != "" {
+		t.Errorf("expected no image and no oEmbed for the search, got %+v", search)
+	}
+
+	source := searchSource(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}", maimaiPage(*loadTemplates("templates").Lookup("maimai.html"), source, []string{"hans", "fritz"}))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/2020/CW_53/1", nil))
+	for _, tag := range []string{
+		`<meta property="og:title" content="Frühling im Lockdown" />`,
+		`<meta property="og:image" content="http://example.com/mm/2020/CW_53/1_hans_1.png" />`,
+		`<meta property="og:image:alt" content="Eine Taube" />`,
+		`<meta name="twitter:card" content="summary_large_image" />`,
+		`type="application/json+oembed"`,
+	} {
+		if !strings.Contains(resp.Body.String(), tag) {
+			t.Errorf("expected %s in the page", tag)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -12,36 +14,119 @@ import (
 // MaimaiSource is a directory that containes all maimais
 type MaimaiSource string
 
+// FilePath returns the path of the maimai's file in the source directory
+func (m MaimaiSource) FilePath(mm Maimai) string {
+	return filepath.Join(string(m), mm.Href())
+}
+
 // GetMaimaisForCW reads all data from directory and returns a Week struct with the information
+// Maimais and templates that are scheduled for later are kept apart from the published ones.
 func (m MaimaiSource) GetMaimaisForCW(cw CW) (*Week, error) {
 	imgFiles, err := GetImageFiles(filepath.Join(string(m), cw.Path()))
 	if err != nil {
 		return nil, err
 	}
+	meta, err := m.ReadMetadata(cw)
+	if err != nil {
+		log.Errorf("cannot read metadata of %s: %v", cw.Path(), err)
+		meta = WeekMetadata{}
+	}
 	week := Week{
 		Maimais: []UserMaimai{},
 		CW:      cw,
 	}
+	now := time.Now()
 	for _, img := range imgFiles {
 
-		if !strings.HasPrefix(img.Name(), "template.") {
+		if !isTemplateFile(img.Name()) {
 			mm, err := NewUserMaimai(img.Name(), img.ModTime(), cw)
 			if err != nil {
 				log.Errorf("error in %s/%s: %v", cw.Path(), img.Name(), err)
 				continue
 			}
-			week.Maimais = append(week.Maimais, *mm)
+			mm.Meta = meta[img.Name()]
+			if !mm.Meta.UploadTime.IsZero() {
+				mm.UploadTime = mm.Meta.UploadTime
+			}
+			if !mm.Meta.PublishAt.IsZero() {
+				mm.UploadTime = mm.Meta.PublishAt
+			}
+			if mm.Meta.IsScheduled(now) {
+				week.Scheduled = append(week.Scheduled, *mm)
+			} else {
+				week.Maimais = append(week.Maimais, *mm)
+			}
 		} else {
-			week.Template = &Template{
-				ImageType: strings.TrimPrefix(filepath.Ext(img.Name()), "."), // trim dot at start with TrimPrefix
-				CW:        cw,
+			template, err := NewTemplate(img.Name(), cw)
+			if err != nil {
+				log.Errorf("error in %s/%s: %v", cw.Path(), img.Name(), err)
+				continue
+			}
+			template.Meta = meta[img.Name()]
+			if template.Meta.IsScheduled(now) {
+				week.ScheduledTemplates = append(week.ScheduledTemplates, *template)
+			} else {
+				week.Templates = append(week.Templates, *template)
 			}
 		}
 	}
+	sort.Slice(week.Templates, func(i, j int) bool {
+		return week.Templates[i].Number < week.Templates[j].Number
+	})
 	week.SortMaimais()
 	return &week, nil
 }
 
+// IsPublic checks if the file at the path relative to the source can be served
+// Only maimais, templates, poster frames and user images are public, scheduled maimais and
+// templates not before they are published. Metadata, temporary files and folders are private.
+func (m MaimaiSource) IsPublic(name string) bool {
+	parts := strings.Split(path.Clean("/" + name)[1:], "/")
+	fileName := parts[len(parts)-1]
+	ext := strings.TrimPrefix(path.Ext(fileName), ".")
+	if strings.HasPrefix(fileName, ".") || !isMediaExtension(ext) {
+		return false
+	}
+	if len(parts) == 2 && parts[0] == "users" {
+		return true
+	}
+	if len(parts) != 3 && !(len(parts) == 4 && parts[2] == posterFolder) {
+		return false
+	}
+	cw, err := CWFromPath(path.Join(parts[0], parts[1]))
+	if err != nil || cw.Path() != path.Join(parts[0], parts[1]) {
+		return false
+	}
+	meta, err := m.ReadMetadata(*cw)
+	if err != nil {
+		log.Errorf("cannot read metadata of %s: %v", cw.Path(), err)
+		return false
+	}
+	now := time.Now()
+	if len(parts) == 3 {
+		return !meta[fileName].IsScheduled(now)
+	}
+	// poster frames belong to the video with the same name
+	for video, videoMeta := range meta {
+		if strings.TrimSuffix(video, path.Ext(video)) == strings.TrimSuffix(fileName, "."+ext) {
+			return !videoMeta.IsScheduled(now)
+		}
+	}
+	return true
+}
+
+// maimaiFileServer serves the public files of the source, see IsPublic
+func maimaiFileServer(source MaimaiSource) http.Handler {
+	files := http.FileServer(http.Dir(string(source)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !source.IsPublic(r.URL.Path) {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
 // Finds a calender week folders for a given year
 func (m MaimaiSource) GetCWsOfYear(year int) ([]CW, error) {
 	yearS := strconv.Itoa(year)
@@ -63,6 +148,64 @@ func (m MaimaiSource) GetCWsOfYear(year int) ([]CW, error) {
 	return CWs, nil
 }
 
+// IsNavigable checks if the calender week can be linked in the week navigation:
+// it is not before the first year and not after the current week
+func (m MaimaiSource) IsNavigable(cw CW) bool {
+	years := m.GetYears()
+	return len(years) > 0 && cw.Year >= years[0] && !Weeks.Current().Before(cw)
+}
+
+// NeighbourCWs returns the calender weeks before and after cw to navigate to, nil if there is none
+// Weeks before the first year or after the current week are not linked.
+// If skipEmpty is set, weeks without published maimais are skipped, even across years.
+// Otherwise weeks without a folder are linked too, the week page shows them empty.
+func (m MaimaiSource) NeighbourCWs(cw CW, skipEmpty bool) (prev *CW, next *CW) {
+	current := Weeks.Current()
+	years := m.GetYears()
+	if !skipEmpty {
+		p, n := cw.Prev(), cw.Next()
+		if m.IsNavigable(p) {
+			prev = &p
+		}
+		if m.IsNavigable(n) {
+			next = &n
+		}
+		return prev, next
+	}
+
+	cws := make([]CW, 0)
+	for _, year := range years {
+		yearCWs, err := m.GetCWsOfYear(year)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		cws = append(cws, yearCWs...)
+	}
+	sort.Slice(cws, func(i, j int) bool {
+		return cws[i].Before(cws[j])
+	})
+	hasMaimais := func(c CW) bool {
+		week, err := m.GetMaimaisForCW(c)
+		if err != nil {
+			log.Error(err)
+			return false
+		}
+		return len(week.Maimais) > 0
+	}
+	for i := len(cws) - 1; i >= 0 && prev == nil; i-- {
+		if cws[i].Before(cw) && hasMaimais(cws[i]) {
+			prev = &cws[i]
+		}
+	}
+	for i := 0; i < len(cws) && next == nil; i++ {
+		if cw.Before(cws[i]) && !current.Before(cws[i]) && hasMaimais(cws[i]) {
+			next = &cws[i]
+		}
+	}
+	return prev, next
+}
+
 // returns all user listed in "users.txt"
 func (m MaimaiSource) GetUsers() ([]string, error) {
 	data, err := os.ReadFile(path.Join(string(m), "users.txt"))
@@ -85,8 +228,7 @@ func (m MaimaiSource) GetYears() []int {
 	yearFolders, err := filepath.Glob(path.Join(string(m), "[0-9][0-9][0-9][0-9]"))
 	if err != nil {
 		log.Error(err)
-		year, _ := time.Now().ISOWeek()
-		now := [1]int{year}
+		now := [1]int{Weeks.Current().Year}
 		return now[:]
 	}
 	years := make([]int, len(yearFolders))
@@ -0,0 +1,122 @@
+// live updates of the maimai cards via server-sent events
+// new and changed cards are taken from a fresh copy of the current page,
+// events that arrive close together share one copy
+(function () {
+    if (!window.EventSource) {
+        return;
+    }
+    const events = new EventSource('/events');
+
+    function cardId(e) {
+        return `maimai-${e.cw.Year}-${e.cw.Week}-${e.counter}`;
+    }
+
+    function cwPath(cw) {
+        return `${cw.Year}/CW_${String(cw.Week).padStart(2, '0')}`;
+    }
+
+    // time in ms that events are collected before the page is fetched
+    const delay = 500;
+    let pending = [];
+    let timer = null;
+
+    function fetchPage() {
+        return fetch(location.href, { credentials: 'same-origin' })
+            .then(r => r.text())
+            .then(html => new DOMParser().parseFromString(html, 'text/html'));
+    }
+
+    // update applies a change from the fresh copy of the page,
+    // a batch upload of many files results in a single fetch
+    function update(apply) {
+        pending.push(apply);
+        if (timer) {
+            return;
+        }
+        timer = setTimeout(() => {
+            const updates = pending;
+            pending = [];
+            timer = null;
+            fetchPage().then(doc => updates.forEach(apply => apply(doc)));
+        }, delay);
+    }
+
+    events.addEventListener('upload', msg => {
+        const e = JSON.parse(msg.data);
+        const main = document.querySelector('main[data-year]');
+        const week = document.querySelector(`.week[data-cw="${cwPath(e.cw)}"]`);
+        if (document.getElementById(cardId(e)) || !(week || (main && main.dataset.year == e.cw.Year))) {
+            return;
+        }
+        update(doc => {
+            const card = doc.getElementById(cardId(e));
+            if (!card || document.getElementById(cardId(e))) {
+                return;
+            }
+            const current = document.querySelector(`.week[data-cw="${cwPath(e.cw)}"]`);
+            if (current) {
+                const maimais = current.querySelector('.maimais');
+                const template = maimais.querySelector('.templates');
+                maimais.insertBefore(card, template ? template.nextSibling : maimais.firstChild);
+            } else {
+                // first upload of a new week
+                const newWeek = card.closest('.week');
+                main.insertBefore(newWeek, main.querySelector('.week, .elevator-button'));
+            }
+        });
+    });
+
+    function onChange(msg) {
+        const e = JSON.parse(msg.data);
+        if (!document.getElementById(cardId(e))) {
+            return;
+        }
+        update(doc => {
+            const card = doc.getElementById(cardId(e));
+            const old = document.getElementById(cardId(e));
+            if (card && old) {
+                old.replaceWith(card);
+            }
+        });
+    }
+
+    events.addEventListener('template', msg => {
+        const e = JSON.parse(msg.data);
+        const main = document.querySelector('main[data-year]');
+        const week = document.querySelector(`.week[data-cw="${cwPath(e.cw)}"]`);
+        if (!(week || (main && main.dataset.year == e.cw.Year))) {
+            return;
+        }
+        update(doc => {
+            const templates = doc.querySelector(`.week[data-cw="${cwPath(e.cw)}"] .templates`);
+            if (!templates) {
+                return;
+            }
+            const week = document.querySelector(`.week[data-cw="${cwPath(e.cw)}"]`);
+            if (!week) {
+                // first template of a new week
+                main.insertBefore(templates.closest('.week'), main.querySelector('.week, .elevator-button'));
+                return;
+            }
+            const old = week.querySelector('.templates');
+            if (old) {
+                old.replaceWith(templates);
+            } else {
+                const maimais = week.querySelector('.maimais');
+                maimais.insertBefore(templates, maimais.firstChild);
+            }
+        });
+    });
+
+    events.addEventListener('delete', msg => {
+        const card = document.getElementById(cardId(JSON.parse(msg.data)));
+        if (card) {
+            card.remove();
+        }
+    });
+
+    events.addEventListener('reaction', onChange);
+    events.addEventListener('comment', onChange);
+    events.addEventListener('caption', onChange);
+    events.addEventListener('tag', onChange);
+})();
@@ -6,19 +6,49 @@ window.onload = function () {
     });
 }
 
+// drop files anywhere on the upload form to select them
+const uploader = document.querySelector('.uploader');
+if (uploader) {
+    const fileInput = uploader.querySelector('input[type=file]');
+    uploader.addEventListener('dragover', e => {
+        e.preventDefault();
+        uploader.classList.add('dragover');
+    });
+    uploader.addEventListener('dragleave', () => uploader.classList.remove('dragover'));
+    uploader.addEventListener('drop', e => {
+        e.preventDefault();
+        uploader.classList.remove('dragover');
+        fileInput.files = e.dataTransfer.files;
+    });
+}
+
+// replacing a template also selects its week
+const templateUpload = document.querySelector('.template-upload');
+if (templateUpload) {
+    const week = templateUpload.querySelector('select[name=week]');
+    templateUpload.querySelector('select[name=replace]').addEventListener('change', e => {
+        const option = e.target.selectedOptions[0];
+        if (option && option.dataset.week) {
+            week.value = option.dataset.week;
+        }
+    });
+}
+
 function subscribe(registration) {
     registration.pushManager.subscribe({
         userVisibleOnly: true,
         applicationServerKey: urlBase64ToUint8Array(vapidPublicKey),
-    }).then(function (subscription) {
-        fetch("subscribe", {
-            method: "POST",
-            body: JSON.stringify(subscription),
-            headers: {
-                "Content-type": "application/json; charset=UTF-8"
-            }
-        })
-    }).catch(e=>console.error("cannot subscribe:",e))
+    }).then(saveSubscription).catch(e=>console.error("cannot subscribe:",e))
+}
+
+function saveSubscription(subscription) {
+    fetch("subscribe", {
+        method: "POST",
+        body: JSON.stringify(subscription),
+        headers: {
+            "Content-type": "application/json; charset=UTF-8"
+        }
+    })
 }
 
 function urlBase64ToUint8Array(base64String) {
@@ -35,6 +65,9 @@ if ('serviceWorker' in navigator) {
         registration.pushManager.getSubscription().then((subscription) => {
             if (!subscription) {
                 subscribe(registration);
+            } else {
+                // subscriptions saved before users were recorded get the user this way
+                saveSubscription(subscription);
             }
         });
     })
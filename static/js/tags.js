@@ -0,0 +1,41 @@
+// autocompletion of comma separated tags with the tags of all maimais
+(function () {
+    const inputs = document.querySelectorAll('input[name=tags][list]');
+    if (inputs.length === 0) {
+        return;
+    }
+    let tags = null;
+
+    function loadTags() {
+        if (!tags) {
+            tags = fetch('/tags', { credentials: 'same-origin', headers: { Accept: 'application/json' } })
+                .then(r => r.json())
+                .then(list => list.map(t => t.tag))
+                .catch(() => []);
+        }
+        return tags;
+    }
+
+    inputs.forEach(input => {
+        const datalist = document.getElementById(input.getAttribute('list'));
+
+        function update() {
+            loadTags().then(all => {
+                const parts = input.value.split(',').map(p => p.trim());
+                const current = parts.pop().replace(/^#/, '').toLowerCase();
+                const prefix = parts.filter(p => p).join(', ');
+                const options = all
+                    .filter(t => t.startsWith(current) && !parts.includes(t))
+                    .slice(0, 20)
+                    .map(t => {
+                        const option = document.createElement('option');
+                        option.value = prefix ? `${prefix}, ${t}` : t;
+                        return option;
+                    });
+                datalist.replaceChildren(...options);
+            });
+        }
+        input.addEventListener('focus', update);
+        input.addEventListener('input', update);
+    });
+})();
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// weekdayNames are the short german names of the weekdays, monday first
+var weekdayNames = []string{"Mo", "Di", "Mi", "Do", "Fr", "Sa", "So"}
+
+// UserStats are the statistics of a user or of the whole group in a year
+type UserStats struct {
+	User    UserName `json:"user,omitempty"`
+	Uploads int      `json:"uploads"`
+
+	// UploadsPerWeek maps the calender weeks to the number of uploads
+	UploadsPerWeek map[int]int `json:"uploadsPerWeek"`
+
+	// LongestStreak is the longest run of consecutive weeks with uploads in the year
+	LongestStreak int `json:"longestStreak"`
+
+	// CurrentStreak is the run of weeks with uploads up to the current week, across years
+	CurrentStreak int `json:"currentStreak"`
+
+	// Reactions is the number of reactions the maimais received
+	// There is no voting, so reactions count as votes.
+	Reactions int `json:"reactions"`
+
+	// Wins is the number of weeks with the maimai with the most reactions, ties count for everyone
+	// It is not counted for the group.
+	Wins int `json:"wins"`
+
+	// Weekdays and Hours count the uploads by local upload time, monday first
+	Weekdays [7]int  `json:"weekdays"`
+	Hours    [24]int `json:"hours"`
+
+	// Formats maps image types to the number of uploads
+	Formats map[string]int `json:"formats"`
+}
+
+func newUserStats(user UserName) *UserStats {
+	return &UserStats{User: user, UploadsPerWeek: map[int]int{}, Formats: map[string]int{}}
+}
+
+func (s *UserStats) add(m UserMaimai) {
+	s.Uploads++
+	s.UploadsPerWeek[m.CW.Week]++
+	local := m.UploadTime.In(Weeks.Location)
+	s.Weekdays[(int(local.Weekday())+6)%7]++
+	s.Hours[local.Hour()]++
+	s.Formats[m.ImageType]++
+	s.Reactions += m.Meta.ReactionCount()
+}
+
+// MostActiveWeekday returns the short name of the weekday with the most uploads
+func (s UserStats) MostActiveWeekday() string {
+	return weekdayNames[maxIndex(s.Weekdays[:])]
+}
+
+// MostActiveHour returns the hour of the day with the most uploads
+func (s UserStats) MostActiveHour() int {
+	return maxIndex(s.Hours[:])
+}
+
+// FavoriteFormat returns the most used image type
+func (s UserStats) FavoriteFormat() string {
+	favorite := ""
+	for format, n := range s.Formats {
+		if n > s.Formats[favorite] || (n == s.Formats[favorite] && format < favorite) {
+			favorite = format
+		}
+	}
+	return favorite
+}
+
+func maxIndex(values []int) int {
+	max := 0
+	for i, v := range values {
+		if v > values[max] {
+			max = i
+		}
+	}
+	return max
+}
+
+// YearUploads is the number of uploads of all users in a year
+type YearUploads struct {
+	Year    int              `json:"year"`
+	Uploads int              `json:"uploads"`
+	Users   map[UserName]int `json:"users"`
+}
+
+// Stats are the statistics of a year
+type Stats struct {
+	Year int `json:"year"`
+	// Group sums up the statistics of all users
+	Group UserStats `json:"group"`
+	// Users are sorted by number of uploads
+	Users []UserStats `json:"users"`
+	// Years compares the uploads of all years
+	Years []YearUploads `json:"years"`
+}
+
+// ComputeStats calculates the statistics of the year from the weeks of all years
+// current is the current calender week the running streaks end in.
+func ComputeStats(years map[int][]Week, year int, current CW) Stats {
+	stats := Stats{Year: year}
+	group := newUserStats("")
+	users := map[UserName]*UserStats{}
+	for _, week := range years[year] {
+		mostReactions := 0
+		for _, m := range week.Maimais {
+			if n := m.Meta.ReactionCount(); n > mostReactions {
+				mostReactions = n
+			}
+		}
+		for _, m := range week.Maimais {
+			user := UserName(strings.ToLower(string(m.User)))
+			if users[user] == nil {
+				users[user] = newUserStats(user)
+			}
+			users[user].add(m)
+			group.add(m)
+			if mostReactions > 0 && m.Meta.ReactionCount() == mostReactions {
+				users[user].Wins++
+			}
+		}
+	}
+
+	// weeks with uploads of all years for the streaks
+	uploadWeeks := map[UserName]map[CW]bool{"": {}}
+	for y, weeks := range years {
+		for _, week := range weeks {
+			for _, m := range week.Maimais {
+				user := UserName(strings.ToLower(string(m.User)))
+				if uploadWeeks[user] == nil {
+					uploadWeeks[user] = map[CW]bool{}
+				}
+				uploadWeeks[user][week.CW] = true
+				uploadWeeks[""][week.CW] = true
+			}
+		}
+		uploads := YearUploads{Year: y, Users: map[UserName]int{}}
+		for _, week := range weeks {
+			for _, m := range week.Maimais {
+				uploads.Uploads++
+				uploads.Users[UserName(strings.ToLower(string(m.User)))]++
+			}
+		}
+		stats.Years = append(stats.Years, uploads)
+	}
+	sort.Slice(stats.Years, func(i, j int) bool {
+		return stats.Years[i].Year < stats.Years[j].Year
+	})
+
+	for user, s := range users {
+		s.LongestStreak = longestStreak(uploadWeeks[user], year)
+		s.CurrentStreak = currentStreak(uploadWeeks[user], current)
+		stats.Users = append(stats.Users, *s)
+	}
+	group.LongestStreak = longestStreak(uploadWeeks[""], year)
+	group.CurrentStreak = currentStreak(uploadWeeks[""], current)
+	stats.Group = *group
+	sort.Slice(stats.Users, func(i, j int) bool {
+		a, b := stats.Users[i], stats.Users[j]
+		if a.Uploads != b.Uploads {
+			return a.Uploads > b.Uploads
+		}
+		return a.User < b.User
+	})
+	return stats
+}
+
+// longestStreak returns the longest run of consecutive weeks in the year
+func longestStreak(weeks map[CW]bool, year int) int {
+	longest, streak := 0, 0
+	for cw := (CW{Year: year, Week: 1}); cw.Year == year; cw = cw.Next() {
+		if weeks[cw] {
+			streak++
+			if streak > longest {
+				longest = streak
+			}
+		} else {
+			streak = 0
+		}
+	}
+	return longest
+}
+
+// currentStreak returns the run of consecutive weeks up to the current week
+// The current week is not over yet, so the streak may also end in the week before.
+func currentStreak(weeks map[CW]bool, current CW) int {
+	cw := current
+	if !weeks[cw] {
+		cw = cw.Prev()
+	}
+	streak := 0
+	for ; weeks[cw]; cw = cw.Prev() {
+		streak++
+	}
+	return streak
+}
+
+// loadAllYears reads the maimais of all years
+func loadAllYears(source MaimaiSource) (map[int][]Week, error) {
+	years := map[int][]Week{}
+	for _, year := range source.GetYears() {
+		weeks, err := GetMaimais(source, year)
+		if err != nil {
+			return nil, err
+		}
+		years[year] = weeks
+	}
+	return years, nil
+}
+
+// StatsCache keeps the statistics between requests
+// Reading all years takes a while, so they are only read again after a change.
+type StatsCache struct {
+	source MaimaiSource
+	// changes counts the events that change the statistics
+	changes atomic.Int64
+
+	lock    sync.Mutex
+	years   map[int][]Week
+	loaded  int64
+	current CW
+	stats   map[int]Stats
+}
+
+// NewStatsCache creates an empty cache of the statistics of the source
+func NewStatsCache(source MaimaiSource) *StatsCache {
+	return &StatsCache{source: source}
+}
+
+// Watch clears the cache after uploads, deletions and reactions published on the event bus
+// It never returns and should run in its own goroutine.
+func (c *StatsCache) Watch(bus *EventBus) {
+	events, _ := bus.Subscribe()
+	for e := range events {
+		switch e.Type {
+		case EventUpload, EventDelete, EventReaction:
+			c.changes.Add(1)
+		}
+	}
+}
+
+// Get returns the statistics of the year, nil if there are no maimais in the year
+func (c *StatsCache) Get(year int) (*Stats, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// events that arrive while the years are read make the next request read them again
+	changes := c.changes.Load()
+	if c.years == nil || c.loaded != changes {
+		years, err := loadAllYears(c.source)
+		if err != nil {
+			return nil, err
+		}
+		c.years, c.loaded = years, changes
+		c.stats = map[int]Stats{}
+	}
+	// the streaks end in the current week
+	if current := Weeks.Current(); current != c.current {
+		c.current = current
+		c.stats = map[int]Stats{}
+	}
+	if _, ok := c.years[year]; !ok {
+		return nil, nil
+	}
+	stats, ok := c.stats[year]
+	if !ok {
+		stats = ComputeStats(c.years, year, c.current)
+		c.stats[year] = stats
+	}
+	return &stats, nil
+}
+
+// statsCharts are the svg charts of the statistics page
+type statsCharts struct {
+	Weeks    template.HTML
+	Weekdays template.HTML
+	Hours    template.HTML
+	Formats  template.HTML
+	Years    template.HTML
+}
+
+func newStatsCharts(stats Stats, selected UserStats) statsCharts {
+	rows := []string{}
+	values := [][]int{}
+	for _, s := range stats.Users {
+		row := make([]int, WeeksInYear(stats.Year))
+		for week, n := range s.UploadsPerWeek {
+			row[week-1] = n
+		}
+		rows = append(rows, string(s.User))
+		values = append(values, row)
+	}
+
+	hours := make([]string, 24)
+	for i := range hours {
+		hours[i] = strconv.Itoa(i)
+	}
+
+	formats := []string{}
+	for format := range selected.Formats {
+		formats = append(formats, format)
+	}
+	sort.Slice(formats, func(i, j int) bool {
+		a, b := selected.Formats[formats[i]], selected.Formats[formats[j]]
+		return a > b || (a == b && formats[i] < formats[j])
+	})
+	formatCounts := make([]int, len(formats))
+	for i, format := range formats {
+		formatCounts[i] = selected.Formats[format]
+	}
+
+	years := make([]string, len(stats.Years))
+	yearCounts := make([]int, len(stats.Years))
+	for i, y := range stats.Years {
+		years[i] = strconv.Itoa(y.Year)
+		if len(selected.User) > 0 {
+			yearCounts[i] = y.Users[selected.User]
+		} else {
+			yearCounts[i] = y.Uploads
+		}
+	}
+
+	return statsCharts{
+		Weeks:    heatmap(rows, values),
+		Weekdays: barChart(weekdayNames, selected.Weekdays[:]),
+		Hours:    barChart(hours, selected.Hours[:]),
+		Formats:  barChart(formats, formatCounts),
+		Years:    barChart(years, yearCounts),
+	}
+}
+
+// statsHandler shows the statistics of a year (query parameter year, default is the latest year)
+// The charts show the group or the user given by the query parameter user.
+// Clients that accept JSON get the statistics of all users.
+// The statistics are kept in the cache until they change.
+func statsHandler(template template.Template, source MaimaiSource, cache *StatsCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		years := source.GetYears()
+		year := 0
+		for _, y := range years {
+			if y > year {
+				year = y
+			}
+		}
+		if y := r.URL.Query().Get("year"); len(y) > 0 {
+			var err error
+			if year, err = strconv.Atoi(y); err != nil {
+				httpError(w, http.StatusBadRequest)
+				return
+			}
+		}
+		stats, err := cache.Get(year)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if stats == nil {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(stats); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
+		selected := stats.Group
+		user := strings.ToLower(r.URL.Query().Get("user"))
+		for _, s := range stats.Users {
+			if string(s.User) == user {
+				selected = s
+			}
+		}
+		err = template.Execute(w, struct {
+			Stats    Stats
+			Selected UserStats
+			Charts   statsCharts
+			Years    []int
+		}{
+			Stats:    *stats,
+			Selected: selected,
+			Charts:   newStatsCharts(*stats, selected),
+			Years:    years,
+		})
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func statsMaimai(user string, cw CW, counter int, uploadTime time.Time, imageType string, reactions int) UserMaimai {
+	m := UserMaimai{User: UserName(user), CW: cw, Counter: counter, UserCounter: 1, UploadTime: uploadTime, ImageType: imageType}
+	for i := 0; i < reactions; i++ {
+		m.Meta.ToggleReaction(ReactionEmojis[i%len(ReactionEmojis)], UserName(strings.Repeat("x", i/len(ReactionEmojis)+1)))
+	}
+	return m
+}
+
+func TestComputeStats(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	old := Weeks
+	defer func() { Weeks = old }()
+	Weeks = WeekBoundary{Location: berlin}
+
+	// monday 2021-01-04 23:30 utc is tuesday 00:30 in berlin
+	tuesday := time.Date(2021, 1, 4, 23, 30, 0, 0, time.UTC)
+	years := map[int][]Week{
+		2020: {
+			{CW: CW{Year: 2020, Week: 52}, Maimais: []UserMaimai{statsMaimai("hans", CW{Year: 2020, Week: 52}, 1, tuesday, "png", 0)}},
+			{CW: CW{Year: 2020, Week: 53}, Maimais: []UserMaimai{statsMaimai("hans", CW{Year: 2020, Week: 53}, 1, tuesday, "png", 0)}},
+		},
+		2021: {
+			{CW: CW{Year: 2021, Week: 1}, Maimais: []UserMaimai{
+				statsMaimai("hans", CW{Year: 2021, Week: 1}, 1, tuesday, "png", 2),
+				statsMaimai("Fritz", CW{Year: 2021, Week: 1}, 2, tuesday, "gif", 2),
+			}},
+			{CW: CW{Year: 2021, Week: 2}, Maimais: []UserMaimai{
+				statsMaimai("hans", CW{Year: 2021, Week: 2}, 1, tuesday, "mp4", 1),
+				statsMaimai("fritz", CW{Year: 2021, Week: 2}, 2, tuesday, "gif", 3),
+			}},
+			{CW: CW{Year: 2021, Week: 4}, Maimais: []UserMaimai{statsMaimai("hans", CW{Year: 2021, Week: 4}, 1, tuesday, "png", 0)}},
+		},
+	}
+
+	stats := ComputeStats(years, 2021, CW{Year: 2021, Week: 5})
+	if len(stats.Users) != 2 || stats.Users[0].User != "hans" || stats.Users[1].User != "fritz" {
+		t.Fatalf("expected hans and fritz, got %+v", stats.Users)
+	}
+	hans, fritz := stats.Users[0], stats.Users[1]
+	if hans.Uploads != 3 || fritz.Uploads != 2 || stats.Group.Uploads != 5 {
+		t.Errorf("unexpected number of uploads %d, %d and %d", hans.Uploads, fritz.Uploads, stats.Group.Uploads)
+	}
+	if hans.UploadsPerWeek[1] != 1 || hans.UploadsPerWeek[3] != 0 || hans.UploadsPerWeek[4] != 1 {
+		t.Errorf("unexpected uploads per week %v", hans.UploadsPerWeek)
+	}
+	// the streak continues from 2020/53 into 2021/1 but only counts in 2021 for the longest streak
+	if hans.LongestStreak != 2 || fritz.LongestStreak != 2 {
+		t.Errorf("expected longest streaks of 2 weeks, got %d and %d", hans.LongestStreak, fritz.LongestStreak)
+	}
+	// the current week 5 has no upload yet
+	if hans.CurrentStreak != 1 || fritz.CurrentStreak != 0 {
+		t.Errorf("expected current streaks of 1 and 0, got %d and %d", hans.CurrentStreak, fritz.CurrentStreak)
+	}
+	if stats := ComputeStats(years, 2021, CW{Year: 2021, Week: 2}); stats.Users[0].CurrentStreak != 4 {
+		t.Errorf("expected a streak of 4 weeks across the years, got %d", stats.Users[0].CurrentStreak)
+	}
+	if hans.Reactions != 3 || fritz.Reactions != 5 || hans.Wins != 1 || fritz.Wins != 2 {
+		t.Errorf("unexpected reactions and wins %d/%d and %d/%d", hans.Reactions, hans.Wins, fritz.Reactions, fritz.Wins)
+	}
+	if hans.MostActiveWeekday() != "Di" || hans.MostActiveHour() != 0 {
+		t.Errorf("expected tuesday 0:00 in berlin, got %s %d", hans.MostActiveWeekday(), hans.MostActiveHour())
+	}
+	if hans.FavoriteFormat() != "png" || fritz.FavoriteFormat() != "gif" {
+		t.Errorf("unexpected favorite formats %s and %s", hans.FavoriteFormat(), fritz.FavoriteFormat())
+	}
+	if len(stats.Years) != 2 || stats.Years[0].Uploads != 2 || stats.Years[1].Users["fritz"] != 2 {
+		t.Errorf("unexpected comparison of the years %+v", stats.Years)
+	}
+
+	charts := newStatsCharts(stats, hans)
+	if n := strings.Count(string(charts.Weeks), "<rect"); n != 2*52 {
+		t.Errorf("expected 52 weeks for 2 users in the heatmap, got %d cells", n)
+	}
+	if n := strings.Count(string(charts.Hours), "<rect"); n != 24 {
+		t.Errorf("expected 24 bars in the hour chart, got %d", n)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	// the empty week between the others is skipped
+	for _, path := range []string{"2021/CW_01/1_hans_1.png", "2021/CW_02", "2021/CW_03/1_fritz_1.gif"} {
+		path = filepath.Join(string(source), path)
+		if filepath.Ext(path) == "" {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, testPNG(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache := NewStatsCache(source)
+	bus := NewEventBus()
+	go cache.Watch(bus)
+	for bus.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	handler := statsHandler(*loadTemplates("templates").Lookup("stats.html"), source, cache)
+
+	uploads := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/stats?format=json", nil)
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		var stats Stats
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Year != 2021 {
+			t.Errorf("expected the statistics of 2021, got %+v", stats)
+		}
+		return stats.Group.Uploads
+	}
+	if n := uploads(); n != 2 {
+		t.Errorf("expected 2 uploads in 2021, got %d", n)
+	}
+
+	// the statistics are cached until an upload is published
+	writeTestMaimais(t, source, "2021/CW_03/2_hans_1.png")
+	bus.Publish(Event{Type: EventCaption, CW: CW{Year: 2021, Week: 3}, Counter: 1})
+	time.Sleep(10 * time.Millisecond)
+	if n := uploads(); n != 2 {
+		t.Errorf("expected the cached 2 uploads, got %d", n)
+	}
+	bus.Publish(Event{Type: EventUpload, CW: CW{Year: 2021, Week: 3}, Counter: 2})
+	n := uploads()
+	for i := 0; i < 100 && n != 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		n = uploads()
+	}
+	if n != 3 {
+		t.Errorf("expected 3 uploads after the upload event, got %d", n)
+	}
+
+	resp := httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(http.MethodGet, "/stats?year=2021&user=fritz", nil))
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "<svg") {
+		t.Errorf("expected a page with charts, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(http.MethodGet, "/stats?year=2019", nil))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a year without maimais, got %d", resp.Code)
+	}
+}
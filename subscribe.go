@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,13 +15,23 @@ import (
 )
 
 // Subscriptions holds information for push notification subscription
+// The subscriptions are added and sent to from several handlers at once.
 type Subscriptions struct {
 	publicKey         string
 	privateKey        string
-	subscriptions     []webpush.Subscription
+	lock              sync.Mutex
+	subscriptions     []userSubscription
 	subscriptionsFile string
 }
 
+// userSubscription is a push subscription and the user who registered it
+// The user is empty for subscriptions that were saved before users were recorded.
+// Those get no notifications for a single user until the browser sends them again, see Add.
+type userSubscription struct {
+	webpush.Subscription
+	User string `json:"user,omitempty"`
+}
+
 // ReadSubscriptions reads files or creates them if necessary
 func ReadSubscriptions(privateKeyFile, publicKeyFile, subscriptionsFile string) (*Subscriptions, error) {
 	fPub, err := os.OpenFile(publicKeyFile, os.O_RDWR|os.O_CREATE, 0666)
@@ -79,7 +90,7 @@ func ReadSubscriptions(privateKeyFile, publicKeyFile, subscriptionsFile string)
 	subs := Subscriptions{
 		privateKey:        privateKey,
 		publicKey:         pubKey,
-		subscriptions:     []webpush.Subscription{},
+		subscriptions:     []userSubscription{},
 		subscriptionsFile: subscriptionsFile,
 	}
 
@@ -95,7 +106,7 @@ func ReadSubscriptions(privateKeyFile, publicKeyFile, subscriptionsFile string)
 		if len(subsBytes) != 0 {
 			// trim to remove empty last line
 			for _, subJson := range strings.Split(strings.Trim(string(subsBytes), " \n\r"), "\n") {
-				sub := webpush.Subscription{}
+				sub := userSubscription{}
 				err := json.Unmarshal([]byte(subJson), &sub)
 				if err != nil {
 					return nil, fmt.Errorf("invalid subscription body '%v', got error: %v", subJson, err)
@@ -108,34 +119,87 @@ func ReadSubscriptions(privateKeyFile, publicKeyFile, subscriptionsFile string)
 	return &subs, nil
 }
 
-// Add adds a subscription
-func (s *Subscriptions) Add(jsonBytes []byte) error {
-	sub := webpush.Subscription{}
-	err := json.Unmarshal([]byte(jsonBytes), &sub)
+// Add adds a subscription of a user
+// A known subscription is assigned to the user, which migrates subscriptions without user.
+func (s *Subscriptions) Add(user string, jsonBytes []byte) error {
+	sub := userSubscription{}
+	err := json.Unmarshal([]byte(jsonBytes), &sub.Subscription)
 	if err != nil {
 		return fmt.Errorf("invalid subscription body: %v", err)
 	}
+	sub.User = user
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, known := range s.subscriptions {
+		if known.Endpoint != sub.Endpoint {
+			continue
+		}
+		if known.User == sub.User && known.Keys == sub.Keys {
+			return nil
+		}
+		s.subscriptions[i] = sub
+		return s.writeSubscriptions()
+	}
 
+	line, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
 	f, err := os.OpenFile(s.subscriptionsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	f.Write(jsonBytes)
+	f.Write(line)
 	f.WriteString("\n")
 
 	s.subscriptions = append(s.subscriptions, sub)
 	return nil
 }
 
+// writeSubscriptions replaces the subscriptions file, the lock must be held
+func (s *Subscriptions) writeSubscriptions() error {
+	var data bytes.Buffer
+	for _, sub := range s.subscriptions {
+		line, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		data.Write(line)
+		data.WriteString("\n")
+	}
+	return writeFileAtomic(s.subscriptionsFile, data.Bytes())
+}
+
 // Send sends push notification to all subscribers
-func (s Subscriptions) Send(message string) {
+func (s *Subscriptions) Send(message string) {
+	s.lock.Lock()
+	subs := append([]userSubscription{}, s.subscriptions...)
+	s.lock.Unlock()
+	s.send(message, subs)
+}
 
-	worker := func(jobs <-chan webpush.Subscription, wg *sync.WaitGroup) {
+// SendTo sends a push notification to all subscriptions of a user
+func (s *Subscriptions) SendTo(user string, message string) {
+	subs := []userSubscription{}
+	s.lock.Lock()
+	for _, sub := range s.subscriptions {
+		if strings.EqualFold(sub.User, user) {
+			subs = append(subs, sub)
+		}
+	}
+	s.lock.Unlock()
+	s.send(message, subs)
+}
+
+func (s *Subscriptions) send(message string, subscriptions []userSubscription) {
+
+	worker := func(jobs <-chan userSubscription, wg *sync.WaitGroup) {
 		defer wg.Done()
 		for m := range jobs {
 			// Send Notification
-			resp, err := webpush.SendNotification([]byte(message), &m, &webpush.Options{
+			resp, err := webpush.SendNotification([]byte(message), &m.Subscription, &webpush.Options{
 				Subscriber:      "info@mmotcw.club", // Do not include "mailto:"
 				VAPIDPublicKey:  s.publicKey,
 				VAPIDPrivateKey: s.privateKey,
@@ -143,6 +207,7 @@ func (s Subscriptions) Send(message string) {
 			})
 			if err != nil {
 				log.Errorf("cannot send push notification: %v", err)
+				continue
 			}
 			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 				respBody, _ := io.ReadAll(resp.Body)
@@ -152,7 +217,7 @@ func (s Subscriptions) Send(message string) {
 	}
 
 	var wg sync.WaitGroup
-	var jobs chan webpush.Subscription = make(chan webpush.Subscription)
+	var jobs chan userSubscription = make(chan userSubscription)
 
 	numCores := runtime.NumCPU()
 	wg.Add(numCores)
@@ -160,7 +225,7 @@ func (s Subscriptions) Send(message string) {
 		go worker(jobs, &wg)
 	}
 
-	for _, sub := range s.subscriptions {
+	for _, sub := range subscriptions {
 		jobs <- sub
 	}
 	close(jobs)
@@ -176,7 +241,8 @@ func subscribe(s *Subscriptions) http.HandlerFunc {
 			return
 		}
 
-		err = s.Add(data)
+		user, _, _ := r.BasicAuth()
+		err = s.Add(user, data)
 		if err != nil {
 			log.Error("cannot process subscription: ", err)
 			httpError(w, http.StatusBadRequest)
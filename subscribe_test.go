@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+func TestSubscriptionsConcurrently(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subscriptions")
+	s := &Subscriptions{subscriptionsFile: file}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"endpoint": "https://push.example.com/%d", "keys": {"p256dh": "key", "auth": "auth"}}`, i)
+			if err := s.Add("hans", []byte(body)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		// nobody else subscribed, so nothing is sent
+		go func() {
+			defer wg.Done()
+			s.SendTo("fritz", "hallo")
+		}()
+	}
+	wg.Wait()
+
+	if len(s.subscriptions) != 20 {
+		t.Errorf("expected 20 subscriptions, got %d", len(s.subscriptions))
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 20 {
+		t.Errorf("expected 20 lines in the subscriptions file, got %d", len(lines))
+	}
+}
+
+func TestSubscriptionsMigrate(t *testing.T) {
+	dir := t.TempDir()
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"endpoint": "https://push.example.com/1", "keys": {"p256dh": "key", "auth": "auth"}}`
+	for name, content := range map[string]string{"sub_key": privateKey, "sub_key.pub": publicKey, "subscriptions": legacy + "\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "subscriptions")
+	s, err := ReadSubscriptions(filepath.Join(dir, "sub_key"), filepath.Join(dir, "sub_key.pub"), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.subscriptions) != 1 || s.subscriptions[0].User != "" {
+		t.Fatalf("expected a subscription without user, got %+v", s.subscriptions)
+	}
+
+	// the browser sends the known subscription again
+	for i := 0; i < 2; i++ {
+		if err := s.Add("hans", []byte(legacy)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.subscriptions) != 1 || s.subscriptions[0].User != "hans" {
+		t.Errorf("expected the subscription to belong to hans, got %+v", s.subscriptions)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"user":"hans"`) {
+		t.Errorf("expected the migrated subscription in the file, got %s", data)
+	}
+}
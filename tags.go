@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// EventTag is published when the tags or tag suggestions of a maimai change
+const EventTag = "tag"
+
+const (
+	// maxTags limits the number of tags of a maimai
+	maxTags = 10
+	// maxTagSuggestions limits the number of open suggestions of a maimai
+	maxTagSuggestions = 20
+	// maxTagLength limits the number of characters of a tag
+	maxTagLength = 32
+)
+
+// normalizeTag turns user input into a tag
+// Tags are lower case, a leading # is removed and spaces become dashes.
+// Returns an empty string if nothing is left.
+func normalizeTag(s string) string {
+	s = strings.TrimLeft(strings.TrimSpace(strings.ToLower(s)), "#")
+	tag := []rune{}
+	for _, r := range strings.Join(strings.Fields(s), "-") {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			tag = append(tag, r)
+		}
+	}
+	if len(tag) > maxTagLength {
+		tag = tag[:maxTagLength]
+	}
+	return strings.Trim(string(tag), "-")
+}
+
+// parseTags reads a comma separated list of at most maxTags tags
+// Duplicates and empty tags are dropped.
+func parseTags(s string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(s, ",") {
+		if tag := normalizeTag(t); len(tag) > 0 && !contains(tags, tag) && len(tags) < maxTags {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// SetTags replaces the tags of the maimai
+// Suggestions of tags the maimai now has are removed.
+func (m *Metadata) SetTags(tags []string) {
+	if len(tags) > maxTags {
+		tags = tags[:maxTags]
+	}
+	m.Tags = tags
+	if len(m.Tags) == 0 {
+		m.Tags = nil
+	}
+	for _, tag := range tags {
+		delete(m.SuggestedTags, tag)
+	}
+	if len(m.SuggestedTags) == 0 {
+		m.SuggestedTags = nil
+	}
+}
+
+// SuggestTags adds the suggestions of the user and returns the tags that are new
+// Tags the maimai already has are ignored.
+func (m *Metadata) SuggestTags(tags []string, user UserName) []string {
+	added := []string{}
+	for _, tag := range tags {
+		if contains(m.Tags, tag) {
+			continue
+		}
+		users, ok := m.SuggestedTags[tag]
+		if !ok && len(m.SuggestedTags) >= maxTagSuggestions {
+			break
+		}
+		if m.SuggestedTags == nil {
+			m.SuggestedTags = map[string][]UserName{}
+		}
+		if !ok {
+			added = append(added, tag)
+		}
+		if !containsUser(users, user) {
+			m.SuggestedTags[tag] = append(users, user)
+		}
+	}
+	return added
+}
+
+// RejectTag removes a suggested tag
+func (m *Metadata) RejectTag(tag string) {
+	delete(m.SuggestedTags, tag)
+	if len(m.SuggestedTags) == 0 {
+		m.SuggestedTags = nil
+	}
+}
+
+// Suggestions returns the suggested tags in alphabetical order
+func (m Metadata) Suggestions() []string {
+	tags := make([]string, 0, len(m.SuggestedTags))
+	for tag := range m.SuggestedTags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func containsUser(users []UserName, user UserName) bool {
+	for _, u := range users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// editTags lets the uploader of a maimai set its tags and everybody else suggest tags
+// The uploader sends the comma separated list "tags" or accepts or rejects
+// a single suggestion with "accept" or "reject", the others send "tags" to suggest.
+func editTags(source MaimaiSource, s *Subscriptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, http.StatusMethodNotAllowed)
+			return
+		}
+		user, _, ok := r.BasicAuth()
+		if !ok {
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+
+		maimai, err := findMaimai(source, r)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if maimai == nil {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		owner := maimai.By(user)
+
+		var suggested []string
+		var meta Metadata
+		err = source.UpdateMetadata(maimai.CW, maimai.FileName(), func(m *Metadata) {
+			switch {
+			case !owner:
+				suggested = m.SuggestTags(parseTags(r.FormValue("tags")), UserName(strings.ToLower(user)))
+			case len(r.FormValue("accept")) > 0:
+				if tag := normalizeTag(r.FormValue("accept")); len(tag) > 0 && !contains(m.Tags, tag) {
+					m.SetTags(append(m.Tags, tag))
+				}
+			case len(r.FormValue("reject")) > 0:
+				m.RejectTag(normalizeTag(r.FormValue("reject")))
+			default:
+				m.SetTags(parseTags(r.FormValue("tags")))
+			}
+			meta = *m
+		})
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		Events.Publish(NewMaimaiEvent(EventTag, *maimai, user))
+
+		if len(suggested) > 0 {
+			go s.SendTo(string(maimai.User), fmt.Sprintf("%s schlägt #%s für dein Maimai vor", user, strings.Join(suggested, " #")))
+		}
+
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(struct {
+				Tags        []string `json:"tags"`
+				Suggestions []string `json:"suggestions"`
+			}{
+				Tags:        append([]string{}, meta.Tags...),
+				Suggestions: meta.Suggestions(),
+			})
+			if err != nil {
+				log.Error(err)
+			}
+			return
+		}
+		redirectBack(w, r, maimai.Permalink())
+	}
+}
+
+// groupByWeek puts maimais that are sorted by week into weeks
+func groupByWeek(maimais []UserMaimai) []Week {
+	weeks := []Week{}
+	for _, m := range maimais {
+		if len(weeks) == 0 || weeks[len(weeks)-1].CW != m.CW {
+			weeks = append(weeks, Week{CW: m.CW})
+		}
+		weeks[len(weeks)-1].Maimais = append(weeks[len(weeks)-1].Maimais, m)
+	}
+	return weeks
+}
+
+// tagPage lists all tags (/tags) or the maimais of all years with a tag (/tags/{tag})
+// Clients that accept JSON get the tags with their number of maimais or the permalinks of the maimais.
+func tagPage(template template.Template, source MaimaiSource, index *SearchIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		tag, ok := mux.Vars(r)["tag"]
+		if !ok {
+			tags := index.Tags()
+			if wantsJSON(r) {
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(tags); err != nil {
+					log.Error(err)
+				}
+				return
+			}
+			err := template.Execute(w, struct {
+				Tags  []TagCount
+				Tag   string
+				Weeks []Week
+				User  string
+			}{
+				Tags: tags,
+				User: user,
+			})
+			if err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
+		if normalized := normalizeTag(tag); normalized != tag {
+			if len(normalized) == 0 {
+				httpError(w, http.StatusNotFound)
+				return
+			}
+			http.Redirect(w, r, "/tags/"+normalized, http.StatusMovedPermanently)
+			return
+		}
+		maimais := loadMaimais(source, index.Search(SearchQuery{Tag: tag}))
+		// newest weeks first, the maimais of a week stay newest first
+		sort.SliceStable(maimais, func(i, j int) bool {
+			return maimais[j].CW.Before(maimais[i].CW)
+		})
+
+		if wantsJSON(r) {
+			permalinks := make([]string, len(maimais))
+			for i, m := range maimais {
+				permalinks[i] = m.Permalink()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(permalinks); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+		err := template.Execute(w, struct {
+			Tags  []TagCount
+			Tag   string
+			Weeks []Week
+			User  string
+		}{
+			Tag:   tag,
+			Weeks: groupByWeek(maimais),
+			User:  user,
+		})
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseTags(t *testing.T) {
+	tags := parseTags(" #Katzen, Home Office ,katzen,,#, Ärger!, " + strings.Repeat("x", 40))
+	expected := []string{"katzen", "home-office", "ärger", strings.Repeat("x", maxTagLength)}
+	if strings.Join(tags, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v, got %v", expected, tags)
+	}
+	if tags := parseTags("a,b,c,d,e,f,g,h,i,j,k,l"); len(tags) != maxTags {
+		t.Errorf("expected %d tags, got %d", maxTags, len(tags))
+	}
+}
+
+func TestTagSuggestions(t *testing.T) {
+	meta := Metadata{}
+	meta.SetTags([]string{"katzen"})
+	if added := meta.SuggestTags([]string{"katzen", "montag"}, "fritz"); len(added) != 1 || added[0] != "montag" {
+		t.Errorf("expected only montag to be suggested, got %v", added)
+	}
+	if added := meta.SuggestTags([]string{"montag"}, "otto"); len(added) != 0 || len(meta.SuggestedTags["montag"]) != 2 {
+		t.Errorf("expected the second suggestion to be recorded, got %v", meta.SuggestedTags)
+	}
+	meta.SetTags(append(meta.Tags, "montag"))
+	if len(meta.Suggestions()) != 0 || len(meta.Tags) != 2 {
+		t.Errorf("expected the accepted suggestion to become a tag, got %v and %v", meta.Tags, meta.SuggestedTags)
+	}
+}
+
+func TestEditTags(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	cw := CW{Year: 2021, Week: 5}
+	if err := os.MkdirAll(filepath.Join(string(source), cw.Path()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(string(source), cw.Path(), "1_hans_1.png"), testPNG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}/tags", editTags(source, &Subscriptions{}))
+
+	post := func(user string, values url.Values) (int, []string, []string) {
+		req := httptest.NewRequest(http.MethodPost, "/2021/CW_05/1/tags", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth(user, "")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var result struct {
+			Tags        []string
+			Suggestions []string
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.Code, result.Tags, result.Suggestions
+	}
+
+	if code, tags, _ := post("hans", url.Values{"tags": {"Katzen, Montag"}}); code != http.StatusOK || strings.Join(tags, ",") != "katzen,montag" {
+		t.Errorf("expected the owner to set the tags, got %d %v", code, tags)
+	}
+	if _, tags, suggestions := post("fritz", url.Values{"tags": {"montag, #Kaffee"}}); len(tags) != 2 || strings.Join(suggestions, ",") != "kaffee" {
+		t.Errorf("expected kaffee to be suggested, got %v and %v", tags, suggestions)
+	}
+	if _, tags, suggestions := post("hans", url.Values{"accept": {"kaffee"}}); len(tags) != 3 || len(suggestions) != 0 {
+		t.Errorf("expected kaffee to be accepted, got %v and %v", tags, suggestions)
+	}
+	post("fritz", url.Values{"tags": {"dienstag"}})
+	if _, tags, suggestions := post("hans", url.Values{"reject": {"dienstag"}}); len(tags) != 3 || len(suggestions) != 0 {
+		t.Errorf("expected dienstag to be rejected, got %v and %v", tags, suggestions)
+	}
+
+	meta, err := source.ReadMetadata(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := meta["1_hans_1.png"].Tags; strings.Join(tags, ",") != "katzen,montag,kaffee" {
+		t.Errorf("expected the tags to be stored in the metadata, got %v", tags)
+	}
+}
+
+func TestTagPage(t *testing.T) {
+	source := searchSource(t)
+	for _, m := range []struct {
+		cw       CW
+		fileName string
+		tags     []string
+	}{
+		{CW{Year: 2020, Week: 53}, "1_hans_1.png", []string{"frühling", "taube"}},
+		{CW{Year: 2021, Week: 12}, "1_fritz_1.png", []string{"taube"}},
+	} {
+		tags := m.tags
+		if err := source.UpdateMetadata(m.cw, m.fileName, func(meta *Metadata) { meta.Tags = tags }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, _ := OpenSearchIndex("")
+	if err := index.Rebuild(source); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	handler := tagPage(*loadTemplates("templates").Lookup("tags.html"), source, index)
+	router.HandleFunc("/tags", handler)
+	router.HandleFunc("/tags/{tag}", handler)
+
+	get := func(path string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	var tags []TagCount
+	if err := json.NewDecoder(get("/tags", "application/json").Body).Decode(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0] != (TagCount{Tag: "taube", Count: 2}) {
+		t.Errorf("expected taube to be the most used tag, got %v", tags)
+	}
+
+	var permalinks []string
+	if err := json.NewDecoder(get("/tags/taube", "application/json").Body).Decode(&permalinks); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(permalinks, " ") != "/2021/CW_12/1 /2020/CW_53/1" {
+		t.Errorf("expected the maimais of both years, got %v", permalinks)
+	}
+
+	resp := get("/tags/taube", "text/html")
+	if body := resp.Body.String(); !strings.Contains(body, `id="maimai-2020-53-1"`) || !strings.Contains(body, `id="maimai-2021-12-1"`) {
+		t.Error("expected cards of both maimais")
+	}
+	if resp := get("/tags/Taube", "text/html"); resp.Code != http.StatusMovedPermanently || resp.Header().Get("Location") != "/tags/taube" {
+		t.Errorf("expected a redirect to the normalized tag, got %d", resp.Code)
+	}
+}
+
+func TestUploadTags(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	handler := uploadHandler(source, &Subscriptions{}, NewURLFetcher(1<<20, 0, false), DefaultUploadOptions)
+	resp := httptest.NewRecorder()
+	handler(resp, multipartUploadWithFields(t, map[string]string{"tags": "#Montag, Kaffee"}, testPNG(t)))
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected status 303, got %d: %s", resp.Code, resp.Body.String())
+	}
+	week, err := source.GetMaimaisForCW(Weeks.Current())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(week.Maimais) != 1 || strings.Join(week.Maimais[0].Meta.Tags, ",") != "montag,kaffee" {
+		t.Errorf("expected the tags of the upload to be stored, got %+v", week.Maimais)
+	}
+}
@@ -1,100 +1,591 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
-func uploadHandler(source MaimaiSource, s *Subscriptions) http.HandlerFunc {
+// uploadLock makes sure counters are assigned to one upload at a time
+var uploadLock sync.Mutex
+
+// multipartMemory is the part of a multipart form kept in memory, the rest goes to temporary files
+const multipartMemory = 10 << 20
+
+// maxFilesPerUpload limits the number of files of a single request
+const maxFilesPerUpload = 20
+
+// UploadOptions configures how uploads are processed
+type UploadOptions struct {
+	// OriginalsDir is a private directory that keeps the unmodified uploads
+	// originals are not kept if it is empty
+	OriginalsDir string
+
+	// MaxFileSize is the maximum size of a single file in bytes, 0 means unlimited
+	MaxFileSize int64
+
+	// MaxDimension is the maximum width and height of images in pixels
+	// it protects against decompression bombs
+	MaxDimension int
+
+	// WeeklyQuota is the number of uploads per user and week, 0 means unlimited
+	WeeklyQuota int
+
+	// DuplicateWeeks is the number of calender weeks searched for reposts, 0 disables the search
+	DuplicateWeeks int
+
+	// RejectDuplicates rejects reposts instead of only warning about them
+	RejectDuplicates bool
+}
+
+// DefaultUploadOptions are the limits used if nothing else is configured
+var DefaultUploadOptions = UploadOptions{
+	MaxFileSize:    10 << 20,
+	MaxDimension:   8000,
+	DuplicateWeeks: 4,
+}
+
+// maxRequestSize is the maximum size of a request with files, 0 means unlimited
+func (o UploadOptions) maxRequestSize(files int) int64 {
+	if o.MaxFileSize <= 0 {
+		return 0
+	}
+	return o.MaxFileSize*int64(files) + multipartMemory
+}
+
+// limitRequest rejects requests that are larger than maxSize bytes, 0 means unlimited
+// returns false if the request was rejected
+func limitRequest(w http.ResponseWriter, r *http.Request, maxSize int64) bool {
+	if maxSize <= 0 {
+		return true
+	}
+	if r.ContentLength > maxSize {
+		requestTooLarge(w, maxSize)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	return true
+}
+
+// requestTooLarge answers requests whose body exceeded the limit of http.MaxBytesReader
+func requestTooLarge(w http.ResponseWriter, maxSize int64) {
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	fmt.Fprintf(w, "Das ist zu viel auf einmal, erlaubt sind %s", formatBytes(maxSize))
+}
+
+// UploadResult is the outcome of storing a single uploaded file
+type UploadResult struct {
+	// FileName is the name of the file on the uploaders device
+	FileName string `json:"fileName"`
+	// Maimai is the name the file was stored with
+	Maimai string `json:"maimai,omitempty"`
+	// URL is the permalink of the stored maimai
+	URL   string `json:"url,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Status is the http status code for the file
+	Status int `json:"status"`
+	// Duplicate is the url of a maimai that looks the same as the upload
+	Duplicate string `json:"duplicate,omitempty"`
+	// PublishAt is the time a scheduled maimai becomes visible
+	PublishAt *time.Time `json:"publishAt,omitempty"`
+}
+
+// uploadError is a problem with an uploaded file that is reported to the user
+type uploadError struct {
+	status  int
+	message string
+}
+
+func (e uploadError) Error() string {
+	return e.message
+}
+
+func unsupportedTypeError(mimeType, fileName string) uploadError {
+	return uploadError{
+		status:  http.StatusUnsupportedMediaType,
+		message: fmt.Sprintf("Deine Datei wollen wir hier nicht: %s %s", mimeType, fileName),
+	}
+}
+
+func fetchError(url string, err error) uploadError {
+	if errors.Is(err, errFileTooLarge) {
+		return uploadError{
+			status:  http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("Die Datei hinter %s ist zu groß", url),
+		}
+	}
+	return uploadError{
+		status:  http.StatusBadGateway,
+		message: fmt.Sprintf("Konnte %s nicht holen: %v", url, err),
+	}
+}
+
+func fileTooLargeError(fileName string, maxSize int64) uploadError {
+	return uploadError{
+		status:  http.StatusRequestEntityTooLarge,
+		message: fmt.Sprintf("%s ist zu groß, erlaubt sind %s", fileName, formatBytes(maxSize)),
+	}
+}
+
+func duplicateError(fileName string, duplicate *UserMaimai) uploadError {
+	return uploadError{
+		status:  http.StatusConflict,
+		message: fmt.Sprintf("%s gab es schon: %s", fileName, duplicate.Permalink()),
+	}
+}
+
+func quotaError(quota int) uploadError {
+	return uploadError{
+		status:  http.StatusTooManyRequests,
+		message: fmt.Sprintf("Du hast diese Woche schon %d Maimais pfostiert, mehr geht nicht", quota),
+	}
+}
+
+// formatBytes formats a file size in megabytes
+// e.g. 10 MB
+func formatBytes(size int64) string {
+	return fmt.Sprintf("%d MB", size>>20)
+}
+
+// upload is a file that is about to be stored
+type upload struct {
+	file      io.ReadSeeker
+	fileName  string
+	sourceURL string
+	caption   string
+	alt       string
+	tags      []string
+	// template is the file name of the template the upload is based on
+	template string
+	// err is set if the file could not be received
+	err error
+}
+
+// uploadHandler stores all files of the multipart field "fileToUpload" and all files
+// behind the urls in the field "url" in the current week
+// The i-th "caption", "alt", "tags" and "template" values belong to the i-th file, urls come after files.
+// A single value, like the one of the upload form, belongs to every file.
+// Clients that accept JSON get the per file results, browsers are redirected to the index page.
+func uploadHandler(source MaimaiSource, s *Subscriptions, fetcher *URLFetcher, options UploadOptions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := r.ParseMultipartForm(10 << 20)
+		user, _, ok := r.BasicAuth()
+		if !ok {
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+
+		maxRequestSize := options.maxRequestSize(maxFilesPerUpload)
+		if !limitRequest(w, r, maxRequestSize) {
+			return
+		}
+
+		err := r.ParseMultipartForm(multipartMemory)
+		if err == http.ErrNotMultipart {
+			// uploads by url can be sent as urlencoded form
+			err = r.ParseForm()
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			requestTooLarge(w, maxRequestSize)
+			return
+		}
 		if err != nil {
 			log.Error(err)
 			httpError(w, http.StatusBadRequest)
 			return
 		}
 
-		file, handler, err := r.FormFile("fileToUpload")
-		if err != nil {
-			log.Error(err)
+		uploads := []upload{}
+		if r.MultipartForm != nil {
+			for _, fh := range r.MultipartForm.File["fileToUpload"] {
+				if options.MaxFileSize > 0 && fh.Size > options.MaxFileSize {
+					uploads = append(uploads, upload{fileName: fh.Filename, err: fileTooLargeError(fh.Filename, options.MaxFileSize)})
+					continue
+				}
+				file, err := fh.Open()
+				if err != nil {
+					uploads = append(uploads, upload{fileName: fh.Filename, err: err})
+					continue
+				}
+				defer file.Close()
+				uploads = append(uploads, upload{file: file, fileName: fh.Filename})
+			}
+		}
+		for _, u := range r.PostForm["url"] {
+			u = strings.TrimSpace(u)
+			if len(u) == 0 {
+				continue
+			}
+			file, fileName, err := fetcher.Fetch(r.Context(), u)
+			if err != nil {
+				log.Warnf("cannot fetch %s: %v", u, err)
+				err = fetchError(u, err)
+			}
+			uploads = append(uploads, upload{file: file, fileName: fileName, sourceURL: u, err: err})
+		}
+		if len(uploads) == 0 {
 			httpError(w, http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
+		if len(uploads) > maxFilesPerUpload {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			fmt.Fprintf(w, "Höchstens %d Dateien auf einmal", maxFilesPerUpload)
+			return
+		}
+		for i := range uploads {
+			uploads[i].caption = cleanCaption(formValueAt(r, "caption", i))
+			uploads[i].alt = cleanCaption(formValueAt(r, "alt", i))
+			uploads[i].tags = parseTags(formValueAt(r, "tags", i))
+			uploads[i].template = formValueAt(r, "template", i)
+		}
 
-		mimeType, err := detectType(file)
+		now := time.Now()
+		cw := Weeks.CWAt(now)
+		if r.PostForm.Get("week") == "previous" {
+			previous := Weeks.GraceWeek(now)
+			if previous == nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "Die letzte Woche ist vorbei")
+				return
+			}
+			cw = *previous
+		}
+		publishAt, err := parsePublishTime(r.PostForm.Get("publishAt"))
 		if err != nil {
-			mimeType = ""
-			err = nil
-		}
-		ext := "png"
-		switch mimeType {
-		case "image/gif":
-			ext = "gif"
-		case "image/png":
-			ext = "png"
-		case "image/jpeg":
-			ext = "jpg"
-		default:
-			fmt.Fprintf(w, "Deine Datei wollen wir hier nicht: %s %s", mimeType, handler.Filename)
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		if err := checkPublishTime(publishAt, cw, now); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err)
 			return
 		}
+		saveUploads(w, r, source, s, options, user, cw, publishAt, uploads)
+	}
+}
 
-		year, week := time.Now().ISOWeek()
-		cw := CW{Year: year, Week: week}
+// saveUploads stores the uploads of the user in the calender week, notifies everyone and writes the response
+// The uploads are hidden until publishAt unless it is zero.
+func saveUploads(w http.ResponseWriter, r *http.Request, source MaimaiSource, s *Subscriptions, options UploadOptions, user string, cw CW, publishAt time.Time, uploads []upload) {
+	folderCW, err := checkCWFolder(cw, string(source))
+	if err != nil {
+		log.Error(err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
 
-		path := string(source)
-		log.Info(cw.Path())
-		if err != nil {
-			log.Error(err)
-			httpError(w, http.StatusInternalServerError)
-			return
+	results := make([]UploadResult, len(uploads))
+	stored := []UserMaimai{}
+
+	// the files are checked and hashed before the counters are assigned, so other uploads do not wait for it
+	prepared := make([]*preparedUpload, len(uploads))
+	errs := make([]error, len(uploads))
+	for i, u := range uploads {
+		results[i].FileName = u.fileName
+		if len(u.sourceURL) > 0 {
+			results[i].FileName = u.sourceURL
 		}
+		errs[i] = u.err
+		if errs[i] == nil {
+			prepared[i], errs[i] = prepareUpload(source, options, cw, u.file, Metadata{
+				Uploader:     UserName(user),
+				UploadTime:   time.Now(),
+				OriginalName: u.fileName,
+				SourceURL:    u.sourceURL,
+				Caption:      u.caption,
+				AltText:      u.alt,
+				Template:     u.template,
+				Tags:         u.tags,
+				PublishAt:    publishAt,
+			})
+		}
+	}
 
-		folderCW, err := checkCWFolder(cw, path)
+	uploadLock.Lock()
+	weekData, err := ReadWeek(folderCW)
+	if err != nil {
+		uploadLock.Unlock()
+		for _, p := range prepared {
+			p.discard()
+		}
+		log.Error(err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	counter := weekData.NextCounter()
+	userCounter := weekData.UserUploads(user)
+	for i, u := range uploads {
+		err := errs[i]
+		if err == nil && options.WeeklyQuota > 0 && userCounter >= options.WeeklyQuota {
+			err = quotaError(options.WeeklyQuota)
+		}
+		if err == nil && len(u.template) > 0 && weekData.Template(u.template) == nil {
+			err = uploadError{
+				status:  http.StatusBadRequest,
+				message: fmt.Sprintf("Das Template %s gibt es diese Woche nicht", u.template),
+			}
+		}
+		var maimai *UserMaimai
+		if err == nil {
+			// files of the same batch were not stored yet when the upload was prepared
+			for j := len(stored) - 1; j >= 0 && prepared[i].duplicate == nil; j-- {
+				if (UserMaimai{Meta: prepared[i].meta}).IsDuplicateOf(stored[j]) {
+					prepared[i].duplicate = &stored[j]
+				}
+			}
+			if prepared[i].duplicate != nil && options.RejectDuplicates {
+				err = duplicateError(u.fileName, prepared[i].duplicate)
+			}
+		}
+		if err == nil {
+			target := UserMaimai{
+				User:        UserName(user),
+				Counter:     counter,
+				UserCounter: userCounter,
+				CW:          cw,
+			}
+			maimai, err = storeUpload(source, options, target, prepared[i])
+		}
 		if err != nil {
-			log.Error(err)
-			httpError(w, http.StatusInternalServerError)
-			return
+			prepared[i].discard()
+			if e, ok := err.(uploadError); ok {
+				results[i].Error = e.message
+				results[i].Status = e.status
+			} else {
+				log.Errorf("cannot store %s: %v", results[i].FileName, err)
+				results[i].Error = "500 - server ist kaputt"
+				results[i].Status = http.StatusInternalServerError
+			}
+			continue
+		}
+		results[i].Status = http.StatusCreated
+		results[i].Maimai = maimai.FileName()
+		results[i].URL = maimai.Permalink()
+		if maimai.Meta.IsScheduled(time.Now()) {
+			results[i].PublishAt = &publishAt
+		}
+		if prepared[i].duplicate != nil {
+			results[i].Duplicate = prepared[i].duplicate.Permalink()
 		}
+		stored = append(stored, *maimai)
+		counter++
+		userCounter++
+	}
+	uploadLock.Unlock()
 
-		user, _, ok := r.BasicAuth()
-		if !ok {
-			httpError(w, http.StatusUnauthorized)
-			return
+	for _, m := range stored {
+		if m.IsVideo() {
+			if err := source.EnsurePoster(m); err != nil {
+				log.Warnf("cannot create poster for %s: %v", m.FileName(), err)
+			}
 		}
-		weekData, err := ReadWeek(folderCW)
-		if err != nil {
+	}
+
+	if len(stored) > 0 && stored[0].Meta.IsScheduled(time.Now()) {
+		schedulePublish(s, user, stored, publishAt)
+	} else {
+		for _, m := range stored {
+			Events.Publish(NewMaimaiEvent(EventUpload, m, user))
+		}
+		announceUploads(s, user, len(stored))
+	}
+
+	// if nothing was stored the status of the first file tells why
+	status := http.StatusOK
+	if len(stored) == 0 {
+		status = results[0].Status
+	}
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(results); err != nil {
 			log.Error(err)
-			httpError(w, http.StatusInternalServerError)
 		}
+		return
+	}
+	warnings := false
+	for _, result := range results {
+		warnings = warnings || len(result.Duplicate) > 0
+	}
+	if len(stored) == len(uploads) && !warnings {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(status)
+	for _, result := range results {
+		if len(result.Error) > 0 {
+			fmt.Fprintf(w, "%s: %s\n", result.FileName, result.Error)
+		} else if result.PublishAt != nil {
+			fmt.Fprintf(w, "%s: ok, erscheint am %s\n", result.FileName, result.PublishAt.In(Weeks.Location).Format("02.01.2006 15:04"))
+		} else if len(result.Duplicate) > 0 {
+			fmt.Fprintf(w, "%s: ok, aber das gab es schon: %s\n", result.FileName, result.Duplicate)
+		} else {
+			fmt.Fprintf(w, "%s: ok\n", result.FileName)
+		}
+	}
+}
 
-		cFiles := len(weekData.Maimais) + 1
-		cFilesUser := weekData.UserUploads(user)
-		name := fmt.Sprintf("%d_%s_%d.%s", cFiles, user, cFilesUser, ext)
+// preparedUpload is an uploaded file that is checked, sanitized and hashed but not stored yet
+type preparedUpload struct {
+	// tmpFile is the sanitized file in the folder of the calender week
+	tmpFile  string
+	original []byte
+	ext      string
+	meta     Metadata
+	// duplicate is a maimai of the last weeks the file looks like
+	duplicate *UserMaimai
+}
 
-		osFile, err := os.Create(filepath.Join(folderCW, name))
+// prepareUpload validates the type of an uploaded file and writes it to a temporary file in the calender week
+// Metadata of images and videos is removed before saving, files it cannot be removed from are rejected.
+// The file is hashed and compared with the maimais of the last weeks.
+func prepareUpload(source MaimaiSource, options UploadOptions, cw CW, file io.ReadSeeker, meta Metadata) (*preparedUpload, error) {
+	original, mimeType, ext, err := readUpload(file, meta.OriginalName, options)
+	if err != nil {
+		return nil, err
+	}
+	data, err := SanitizeImage(original, ext)
+	if err != nil {
+		return nil, unsupportedTypeError(mimeType, meta.OriginalName)
+	}
 
+	// the suffix keeps the file out of the week until it is renamed
+	tmp, err := os.CreateTemp(filepath.Join(string(source), cw.Path()), ".upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	p := &preparedUpload{tmpFile: tmp.Name(), original: original, ext: ext, meta: meta}
+	if err != nil {
+		p.discard()
+		return nil, err
+	}
+
+	if err := p.meta.FillFileInfo(p.tmpFile); err != nil {
+		log.Errorf("cannot read file info of %s: %v", meta.OriginalName, err)
+	}
+	if options.DuplicateWeeks > 0 {
+		// videos and animations are hashed by their first frame
+		if err := p.meta.FillPerceptualHash(p.tmpFile); err != nil {
+			log.Warnf("cannot hash %s: %v", meta.OriginalName, err)
+		}
+		p.duplicate = source.FindDuplicate(UserMaimai{CW: cw, UploadTime: meta.UploadTime, Meta: p.meta}, options.DuplicateWeeks)
+		if p.duplicate != nil && options.RejectDuplicates {
+			p.discard()
+			return nil, duplicateError(meta.OriginalName, p.duplicate)
+		}
+	}
+	return p, nil
+}
+
+// discard removes the temporary file of an upload that is not stored
+func (p *preparedUpload) discard() {
+	if p != nil {
+		os.Remove(p.tmpFile)
+	}
+}
+
+// storeUpload saves a prepared upload as the maimai with its metadata
+// maimai is the maimai the file is stored as, its image type is set from the file content
+// The caller holds uploadLock, so the counters of the maimai are not taken.
+func storeUpload(source MaimaiSource, options UploadOptions, maimai UserMaimai, p *preparedUpload) (*UserMaimai, error) {
+	maimai.ImageType = p.ext
+	maimai.UploadTime = p.meta.UploadTime
+
+	if len(options.OriginalsDir) > 0 {
+		originalPath := filepath.Join(options.OriginalsDir, maimai.Href())
+		err := os.MkdirAll(filepath.Dir(originalPath), 0700)
+		if err == nil {
+			err = os.WriteFile(originalPath, p.original, 0600)
+		}
 		if err != nil {
-			log.Error(err)
-			httpError(w, http.StatusInternalServerError)
-			return
+			return nil, fmt.Errorf("cannot keep original: %v", err)
 		}
-		defer osFile.Close()
+	}
 
-		_, err = io.Copy(osFile, file)
+	filePath := source.FilePath(maimai)
+	if _, err := os.Lstat(filePath); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot store %s: file exists", maimai.FileName())
+	}
+	if err := os.Chmod(p.tmpFile, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(p.tmpFile, filePath); err != nil {
+		return nil, err
+	}
+
+	err := source.UpdateMetadata(maimai.CW, maimai.FileName(), func(m *Metadata) {
+		*m = p.meta
+	})
+	if err != nil {
+		// the upload itself succeeded, so we only log the error
+		log.Errorf("cannot save metadata for %s: %v", maimai.FileName(), err)
+	}
+	maimai.Meta = p.meta
+	return &maimai, nil
+}
+
+// readUpload reads an uploaded file and checks its type, file size and dimensions
+// returns the content, mime type and file extension
+func readUpload(file io.ReadSeeker, fileName string, options UploadOptions) ([]byte, string, string, error) {
+	mimeType, err := detectType(file)
+	if err != nil {
+		mimeType = ""
+	}
+	ext, ok := extensionForType(mimeType)
+	if !ok {
+		return nil, "", "", unsupportedTypeError(mimeType, fileName)
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if options.MaxFileSize > 0 && int64(len(data)) > options.MaxFileSize {
+		return nil, "", "", fileTooLargeError(fileName, options.MaxFileSize)
+	}
+	if !isVideoExtension(ext) {
+		// check the size before anything decodes the pixels
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil && ext == "webp" {
+			// extended webp files the Go decoder rejects still have their size in the header
+			config.Width, config.Height, err = webpCanvasSize(data)
+		}
 		if err != nil {
-			log.Error(err)
-			httpError(w, http.StatusInternalServerError)
-			return
+			return nil, "", "", unsupportedTypeError(mimeType, fileName)
+		}
+		if options.MaxDimension > 0 && (config.Width > options.MaxDimension || config.Height > options.MaxDimension) {
+			return nil, "", "", uploadError{
+				status: http.StatusRequestEntityTooLarge,
+				message: fmt.Sprintf("%s ist mit %dx%d Pixeln zu groß, erlaubt sind %d Pixel pro Seite",
+					fileName, config.Width, config.Height, options.MaxDimension),
+			}
 		}
+	}
+	return data, mimeType, ext, nil
+}
 
-		s.Send(fmt.Sprintf("%s has ein Maimai pfostiert", user))
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+// formValueAt returns the i-th value of a form field or an empty string
+// A field with a single value has the same value for all i.
+func formValueAt(r *http.Request, key string, i int) string {
+	values := r.PostForm[key]
+	if len(values) == 1 {
+		return strings.TrimSpace(values[0])
+	}
+	if i < len(values) {
+		return strings.TrimSpace(values[i])
 	}
+	return ""
 }
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func multipartUpload(t *testing.T, files ...[]byte) *http.Request {
+	return multipartUploadWithFields(t, nil, files...)
+}
+
+func multipartUploadWithFields(t *testing.T, fields map[string]string, files ...[]byte) *http.Request {
+	body := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(body)
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	for _, data := range files {
+		part, err := writer.CreateFormFile("fileToUpload", "meme.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth("hans", "")
+	return req
+}
+
+// brokenWebP is an extended WebP file with the given canvas size that the Go decoder cannot read
+func brokenWebP(width, height int) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x02\x00\x00\x00")
+	data = append(data, byte(width-1), byte((width-1)>>8), byte((width-1)>>16))
+	data = append(data, byte(height-1), byte((height-1)>>8), byte((height-1)>>16))
+	// the RIFF size is too small for the VP8X chunk
+	data[4] = 4
+	return data
+}
+
+func TestUploadLimits(t *testing.T) {
+	large := bytes.NewBuffer(nil)
+	if err := png.Encode(large, image.NewGray(image.Rect(0, 0, 200, 10))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		options UploadOptions
+		files   [][]byte
+		status  int
+	}{
+		{"ok", UploadOptions{MaxFileSize: 1 << 20, MaxDimension: 100}, [][]byte{testPNG(t)}, http.StatusSeeOther},
+		{"file size", UploadOptions{MaxFileSize: 10, MaxDimension: 100}, [][]byte{testPNG(t)}, http.StatusRequestEntityTooLarge},
+		{"dimension", UploadOptions{MaxFileSize: 1 << 20, MaxDimension: 100}, [][]byte{large.Bytes()}, http.StatusRequestEntityTooLarge},
+		{"type", UploadOptions{MaxFileSize: 1 << 20, MaxDimension: 100}, [][]byte{[]byte("kein bild")}, http.StatusUnsupportedMediaType},
+		{"unlimited", UploadOptions{MaxFileSize: 0, MaxDimension: 100}, [][]byte{testPNG(t)}, http.StatusSeeOther},
+		{"webp dimension", UploadOptions{MaxFileSize: 1 << 20, MaxDimension: 100}, [][]byte{brokenWebP(200, 10)}, http.StatusRequestEntityTooLarge},
+		{"webp without size", UploadOptions{MaxFileSize: 1 << 20, MaxDimension: 100}, [][]byte{[]byte("RIFF\x04\x00\x00\x00WEBPVP8 ")}, http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := MaimaiSource(t.TempDir())
+			handler := uploadHandler(source, &Subscriptions{}, NewURLFetcher(1<<20, time.Second, false), test.options)
+			resp := httptest.NewRecorder()
+			handler(resp, multipartUpload(t, test.files...))
+			if resp.Code != test.status {
+				t.Errorf("expected status %d, got %d: %s", test.status, resp.Code, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestUploadRequestTooLarge(t *testing.T) {
+	options := UploadOptions{MaxFileSize: 10, MaxDimension: 100}
+	handler := uploadHandler(MaimaiSource(t.TempDir()), &Subscriptions{}, NewURLFetcher(1<<20, time.Second, false), options)
+	req := multipartUpload(t, make([]byte, multipartMemory+1<<10))
+	// chunked requests are only stopped while reading
+	req.ContentLength = -1
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	if resp.Code != http.StatusRequestEntityTooLarge || !strings.Contains(resp.Body.String(), "zu viel") {
+		t.Errorf("expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, resp.Code, resp.Body.String())
+	}
+}
+
+func TestUploadWeeklyQuota(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	options := DefaultUploadOptions
+	options.WeeklyQuota = 2
+	handler := uploadHandler(source, &Subscriptions{}, NewURLFetcher(1<<20, time.Second, false), options)
+
+	resp := httptest.NewRecorder()
+	handler(resp, multipartUpload(t, testPNG(t), testPNG(t), testPNG(t)))
+	if resp.Code != http.StatusOK && resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected first uploads to be stored, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler(resp, multipartUpload(t, testPNG(t)))
+	if resp.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	year, week := time.Now().ISOWeek()
+	w, err := ReadWeek(filepath.Join(string(source), CW{Year: year, Week: week}.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := w.UserUploads("hans"); n != 2 {
+		t.Errorf("expected 2 stored uploads, got %d", n)
+	}
+}
+
+func TestUploadBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testPNG(t))
+	}))
+	defer server.Close()
+
+	// two files and a url, the i-th caption and alt text belong to the i-th upload
+	body := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(body)
+	for _, field := range [][2]string{
+		{"caption", "eins"}, {"caption", "zwei"}, {"caption", "drei"},
+		{"alt", "erstes"}, {"alt", ""}, {"alt", "drittes"},
+		{"url", server.URL + "/meme.png"},
+	} {
+		writer.WriteField(field[0], field[1])
+	}
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{"a.png", testPNG(t)}, {"b.png", []byte("kein bild")}} {
+		part, err := writer.CreateFormFile("fileToUpload", file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file.data)
+	}
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("hans", "")
+
+	source := MaimaiSource(t.TempDir())
+	options := DefaultUploadOptions
+	options.DuplicateWeeks = 0
+	resp := httptest.NewRecorder()
+	uploadHandler(source, &Subscriptions{}, NewURLFetcher(1<<20, time.Second, true), options)(resp, req)
+
+	// the broken file does not stop the others
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for a partly stored batch, got %d", resp.Code)
+	}
+	var results []UploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	cw := Weeks.Current()
+	expected := []UploadResult{
+		{FileName: "a.png", Maimai: "1_hans_0.png", URL: "/" + cw.Path() + "/1", Status: http.StatusCreated},
+		{FileName: "b.png", Status: http.StatusUnsupportedMediaType},
+		{FileName: server.URL + "/meme.png", Maimai: "2_hans_1.png", URL: "/" + cw.Path() + "/2", Status: http.StatusCreated},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %+v", len(expected), results)
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.FileName != e.FileName || r.Maimai != e.Maimai || r.URL != e.URL || r.Status != e.Status || (e.Status == http.StatusCreated) != (len(r.Error) == 0) {
+			t.Errorf("expected %+v at %d, got %+v", e, i, r)
+		}
+	}
+
+	meta, err := source.ReadMetadata(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := meta["1_hans_0.png"]; m.Caption != "eins" || m.AltText != "erstes" || m.OriginalName != "a.png" {
+		t.Errorf("unexpected metadata of the first file %+v", m)
+	}
+	if m := meta["2_hans_1.png"]; m.Caption != "drei" || m.AltText != "drittes" || m.SourceURL != server.URL+"/meme.png" {
+		t.Errorf("unexpected metadata of the url %+v", m)
+	}
+}
+
+func TestUploadBatchSharedFields(t *testing.T) {
+	// the upload form has one caption, alt text and tags field for all files
+	req := multipartUploadWithFields(t, map[string]string{"caption": "Tauben", "alt": "Eine Taube", "tags": "taube, vogel"}, testPNG(t), testPNG(t))
+	source := MaimaiSource(t.TempDir())
+	options := DefaultUploadOptions
+	options.DuplicateWeeks = 0
+	resp := httptest.NewRecorder()
+	uploadHandler(source, &Subscriptions{}, NewURLFetcher(1<<20, time.Second, false), options)(resp, req)
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected status 303, got %d", resp.Code)
+	}
+
+	meta, err := source.ReadMetadata(Weeks.Current())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1_hans_0.png", "2_hans_1.png"} {
+		if m := meta[name]; m.Caption != "Tauben" || m.AltText != "Eine Taube" || strings.Join(m.Tags, " ") != "taube vogel" {
+			t.Errorf("expected the shared fields for %s, got %+v", name, m)
+		}
+	}
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
-// GetImageFiles returns all images files located in the given folder
-// image files end with jpg,jpeg,gif or png
+// GetImageFiles returns all images and video files located in the given folder
+// image files end with jpg, jpeg, gif, png or webp, videos with mp4 or webm
 func GetImageFiles(folder string) ([]os.FileInfo, error) {
 	imgFiles, err := ioutil.ReadDir(folder)
 	if err != nil {
@@ -20,15 +23,8 @@ func GetImageFiles(folder string) ([]os.FileInfo, error) {
 	}
 	images := []os.FileInfo{}
 	for _, img := range imgFiles {
-		if !img.IsDir() {
-			switch filepath.Ext(img.Name())[1:] {
-			case
-				"jpg",
-				"jpeg",
-				"gif",
-				"png":
-				images = append(images, img)
-			}
+		if !img.IsDir() && isMediaExtension(strings.TrimPrefix(filepath.Ext(img.Name()), ".")) {
+			images = append(images, img)
 		}
 	}
 	return images, nil
@@ -41,12 +37,62 @@ func getYear(r *http.Request) int {
 	// change year if year is given
 	if y, ok := mux.Vars(r)["year"]; ok {
 		if yy, err := strconv.Atoi(y); err != nil {
-			year, _ := time.Now().ISOWeek()
-			return year
+			return Weeks.Current().Year
 		} else {
 			return yy
 		}
 	}
-	year, _ := time.Now().ISOWeek()
-	return year
+	return Weeks.Current().Year
+}
+
+// formatTime formats a time as weekday and clock time in the group's time zone
+// e.g. Mo 13:37
+func formatTime(t time.Time) string {
+	t = t.In(Weeks.Location)
+	weekdays := []string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"}
+	return fmt.Sprintf("%s %s", weekdays[t.Weekday()], t.Format("15:04"))
+}
+
+// redirectBack redirects to the page the request came from
+// or to fallback if the referer is unknown or another site
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	target := fallback
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host == r.Host &&
+		strings.HasPrefix(referer.Path, "/") && !strings.HasPrefix(referer.Path, "//") && !strings.HasPrefix(referer.Path, "/\\") {
+		target = referer.Path
+		if len(referer.RawQuery) > 0 {
+			target += "?" + referer.RawQuery
+		}
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// contains checks if the string slice contains s
+func contains(slice []string, s string) bool {
+	for _, e := range slice {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// baseURL returns scheme and host the request was sent to
+// e.g. https://mmotcw.club
+// respects the X-Forwarded-Proto header set by reverse proxies
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); len(proto) > 0 {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// wantsJSON checks if the client prefers a JSON response
+// either by the Accept header or the query parameter format=json
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") || r.URL.Query().Get("format") == "json"
 }
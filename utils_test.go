@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectBack(t *testing.T) {
+	tests := []struct {
+		referer  string
+		expected string
+	}{
+		{"", "/fallback"},
+		{"http://mmotcw.club/2021/CW_05?format=html", "/2021/CW_05?format=html"},
+		{"https://evil.com/2021/CW_05", "/fallback"},
+		{"//evil.com/", "/fallback"},
+		{"http://mmotcw.club//evil.com/", "/fallback"},
+		{"http://mmotcw.club/\\evil.com/", "/fallback"},
+		{"javascript:alert(1)", "/fallback"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://mmotcw.club/2021/CW_05/1/caption", nil)
+		req.Header.Set("Referer", test.referer)
+		resp := httptest.NewRecorder()
+		redirectBack(resp, req, "/fallback")
+		if location := resp.Header().Get("Location"); location != test.expected {
+			t.Errorf("expected redirect from %q to %s, got %s", test.referer, test.expected, location)
+		}
+	}
+}
@@ -12,8 +12,11 @@ type Week struct {
 	CW             CW
 	CanVote        bool
 	FinishedVoting bool
-	// template file name
-	Template *Template
+	// Templates of the week sorted by number
+	Templates []Template
+	// Scheduled and ScheduledTemplates are not published yet
+	Scheduled          []UserMaimai
+	ScheduledTemplates []Template
 }
 
 // SortMaimais sorts the maimais by date
@@ -25,9 +28,10 @@ func (w *Week) SortMaimais() {
 }
 
 // UserUploads counts the users upload in a week
+// scheduled uploads are counted as well
 func (w Week) UserUploads(user string) int {
 	uploads := 0
-	for _, m := range w.Maimais {
+	for _, m := range append(w.Maimais, w.Scheduled...) {
 		if strings.EqualFold(string(m.User), user) {
 			uploads++
 		}
@@ -35,6 +39,94 @@ func (w Week) UserUploads(user string) int {
 	return uploads
 }
 
+// NextCounter returns the counter for the next upload of the week
+func (w Week) NextCounter() int {
+	counter := 1
+	for _, m := range append(w.Maimais, w.Scheduled...) {
+		if m.Counter >= counter {
+			counter = m.Counter + 1
+		}
+	}
+	return counter
+}
+
+// Maimai returns the maimai with the given counter or nil if it does not exist
+func (w Week) Maimai(counter int) *UserMaimai {
+	for i := range w.Maimais {
+		if w.Maimais[i].Counter == counter {
+			return &w.Maimais[i]
+		}
+	}
+	return nil
+}
+
+// Template returns the template with the given file name or nil if it does not exist
+// scheduled templates are included
+func (w Week) Template(fileName string) *Template {
+	for _, t := range append(w.Templates, w.ScheduledTemplates...) {
+		if t.FileName() == fileName {
+			return &t
+		}
+	}
+	return nil
+}
+
+// NextTemplateNumber returns the number for a new template of the week
+func (w Week) NextTemplateNumber() int {
+	number := 1
+	for _, t := range append(w.Templates, w.ScheduledTemplates...) {
+		if t.Number >= number {
+			number = t.Number + 1
+		}
+	}
+	return number
+}
+
+// Thumbnail returns a representative image for the week
+// this is the first template or the first upload if there is no template
+// returns nil if the week is empty
+func (w Week) Thumbnail() Maimai {
+	if len(w.Templates) > 0 {
+		return w.Templates[0]
+	}
+	if len(w.Maimais) == 0 {
+		return nil
+	}
+	first := w.Maimais[0]
+	for _, m := range w.Maimais {
+		if m.Before(first) {
+			first = m
+		}
+	}
+	return first
+}
+
+// Previous returns the maimai that was uploaded before the one with the given counter
+// returns nil if there is none
+func (w Week) Previous(counter int) *UserMaimai {
+	var prev *UserMaimai
+	for i := range w.Maimais {
+		m := &w.Maimais[i]
+		if m.Counter < counter && (prev == nil || m.Counter > prev.Counter) {
+			prev = m
+		}
+	}
+	return prev
+}
+
+// Next returns the maimai that was uploaded after the one with the given counter
+// returns nil if there is none
+func (w Week) Next(counter int) *UserMaimai {
+	var next *UserMaimai
+	for i := range w.Maimais {
+		m := &w.Maimais[i]
+		if m.Counter > counter && (next == nil || m.Counter < next.Counter) {
+			next = m
+		}
+	}
+	return next
+}
+
 // ReadWeek reads all information for week from directory
 func ReadWeek(directory string) (*Week, error) {
 	source := MaimaiSource(filepath.Dir(filepath.Dir(directory)))
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestGetMaimais(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	for _, path := range []string{"2021/CW_01/1_hans_1.png", "2021/CW_03/1_fritz_1.png", "2021/CW_04/1_hans_1.png", "2021/CW_04/2_fritz_1.png"} {
+		path = filepath.Join(string(source), path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// empty weeks between weeks with maimais shifted the following weeks
+	if err := os.MkdirAll(filepath.Join(string(source), "2021", "CW_02"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	weeks, err := GetMaimais(source, 2021)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		week    int
+		maimais int
+	}{{4, 2}, {3, 1}, {1, 1}}
+	if len(weeks) != len(expected) {
+		t.Fatalf("expected %d weeks, got %v", len(expected), weeks)
+	}
+	for i, e := range expected {
+		if weeks[i].CW != (CW{Year: 2021, Week: e.week}) || len(weeks[i].Maimais) != e.maimais {
+			t.Errorf("expected week %d with %d maimais at %d, got %s with %d", e.week, e.maimais, i, weeks[i].CW.Path(), len(weeks[i].Maimais))
+		}
+	}
+}
+
+func TestPreviousNext(t *testing.T) {
+	cw := CW{Year: 2021, Week: 1}
+	week := Week{CW: cw, Maimais: []UserMaimai{
+		{User: "hans", Counter: 4, CW: cw},
+		{User: "fritz", Counter: 2, CW: cw},
+		{User: "hans", Counter: 1, CW: cw},
+	}}
+	tests := []struct {
+		counter  int
+		previous int
+		next     int
+	}{
+		{1, 0, 2},
+		{2, 1, 4},
+		{3, 2, 4},
+		{4, 2, 0},
+	}
+	counter := func(m *UserMaimai) int {
+		if m == nil {
+			return 0
+		}
+		return m.Counter
+	}
+	for _, test := range tests {
+		if prev := counter(week.Previous(test.counter)); prev != test.previous {
+			t.Errorf("expected %d before %d, got %d", test.previous, test.counter, prev)
+		}
+		if next := counter(week.Next(test.counter)); next != test.next {
+			t.Errorf("expected %d after %d, got %d", test.next, test.counter, next)
+		}
+	}
+}
+
+func TestMaimaiPage(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	writeTestMaimais(t, source, "2021/CW_01/1_hans_1.png", "2021/CW_01/2_fritz_1.png", "2021/CW_01/4_hans_2.png", "2021/CW_01/5_fritz_2.png")
+	// the last one is not published yet
+	err := source.UpdateMetadata(CW{Year: 2021, Week: 1}, "5_fritz_2.png", func(m *Metadata) {
+		m.PublishAt = time.Now().Add(time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/{year:202[0-9]}/CW_{week:[0-9]+}/{counter:[0-9]+}", maimaiPage(*loadTemplates("templates").Lookup("maimai.html"), source, []string{"hans", "fritz"}))
+	get := func(url string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+		return resp
+	}
+
+	tests := []struct {
+		url      string
+		previous string
+		next     string
+	}{
+		{"/2021/CW_01/1", "", "/2021/CW_01/2"},
+		{"/2021/CW_01/2", "/2021/CW_01/1", "/2021/CW_01/4"},
+		{"/2021/CW_01/4", "/2021/CW_01/2", ""},
+	}
+	for _, test := range tests {
+		resp := get(test.url)
+		if resp.Code != http.StatusOK {
+			t.Errorf("expected status 200 for %s, got %d", test.url, resp.Code)
+			continue
+		}
+		body := resp.Body.String()
+		for id, href := range map[string]string{"prev": test.previous, "next": test.next} {
+			link := `id="` + id + `" href="` + href + `"`
+			if len(href) == 0 && strings.Contains(body, `id="`+id+`"`) || len(href) > 0 && !strings.Contains(body, link) {
+				t.Errorf("expected %s to link %q as %s", test.url, href, id)
+			}
+		}
+	}
+
+	for _, url := range []string{"/2021/CW_01/3", "/2021/CW_01/5", "/2021/CW_02/1"} {
+		if resp := get(url); resp.Code != http.StatusNotFound {
+			t.Errorf("expected status 404 for %s, got %d", url, resp.Code)
+		}
+	}
+}
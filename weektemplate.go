@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EventTemplate is published when the template of a week changes
+const EventTemplate = "template"
+
+// templateWeek returns the calender week a template is uploaded for
+// "next" selects the week after the current one
+func templateWeek(now time.Time, which string) CW {
+	if which == "next" {
+		now = now.AddDate(0, 0, 7)
+	}
+	return Weeks.CWAt(now)
+}
+
+// canUploadTemplate checks if the user is allowed to set the weekly template
+func canUploadTemplate(user string, masters []string) bool {
+	return contains(masters, strings.ToLower(user))
+}
+
+// errTemplateNotFound is returned when a template that should be replaced does not exist
+var errTemplateNotFound = errors.New("template not found")
+
+// StoreTemplate saves a template of a calender week
+// replace is the file name of the template that is replaced, even if it has a different image type.
+// A new template is added if replace is empty.
+// The title is kept when a template is replaced without a new title.
+// The template is hidden until publishAt unless it is zero.
+func (m MaimaiSource) StoreTemplate(cw CW, data []byte, ext string, replace string, title string, publishAt time.Time) (*Template, error) {
+	folder, err := checkCWFolder(cw, string(m))
+	if err != nil {
+		return nil, err
+	}
+
+	uploadLock.Lock()
+	defer uploadLock.Unlock()
+	week, err := m.GetMaimaisForCW(cw)
+	if err != nil {
+		return nil, err
+	}
+	template := Template{CW: cw, ImageType: ext, Number: week.NextTemplateNumber()}
+	var old *Template
+	if len(replace) > 0 {
+		if old = week.Template(replace); old == nil {
+			return nil, errTemplateNotFound
+		}
+		template.Number = old.Number
+		template.Meta = old.Meta
+		// the text of the old image is recognized again
+		template.Meta.OCRText = ""
+		template.Meta.OCRTime = time.Time{}
+		template.Meta.OCRErrors = 0
+	}
+	if len(title) > 0 {
+		template.Meta.Caption = title
+	}
+	template.Meta.PublishAt = publishAt
+
+	tmp := filepath.Join(folder, ".template.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, m.FilePath(template)); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	ImgCache.Forget(template.Href())
+	// the rename already replaced an old template with the same image type
+	if old != nil && old.FileName() != template.FileName() {
+		if err := os.Remove(m.FilePath(*old)); err != nil {
+			log.Errorf("cannot remove %s: %v", old.FileName(), err)
+		}
+		ImgCache.Forget(old.Href())
+	}
+
+	err = m.UpdateWeekMetadata(cw, func(meta WeekMetadata) error {
+		if old != nil {
+			delete(meta, old.FileName())
+		}
+		meta[template.FileName()] = template.Meta
+		return nil
+	})
+	if err != nil {
+		log.Errorf("cannot save metadata for %s: %v", template.FileName(), err)
+	}
+	return &template, nil
+}
+
+// announceTemplate notifies the pages and subscribers about a new template
+func announceTemplate(s *Subscriptions, user string, template Template) {
+	Events.Publish(Event{Type: EventTemplate, CW: template.CW, User: UserName(user)})
+	s.Send(fmt.Sprintf("%s für KW %d ist da", template.Title(), template.CW.Week))
+}
+
+// templateUploadHandler lets template masters upload or replace templates
+// of the current or the next calender week
+// The week is selected with the form field "week" ("current" or "next"),
+// "replace" is the file name of the template to replace and "title" its title.
+func templateUploadHandler(source MaimaiSource, s *Subscriptions, options UploadOptions, masters []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, http.StatusMethodNotAllowed)
+			return
+		}
+		user, _, ok := r.BasicAuth()
+		if !ok {
+			httpError(w, http.StatusUnauthorized)
+			return
+		}
+		if !canUploadTemplate(user, masters) {
+			httpError(w, http.StatusForbidden)
+			return
+		}
+
+		maxRequestSize := options.maxRequestSize(1)
+		if !limitRequest(w, r, maxRequestSize) {
+			return
+		}
+		file, fh, err := r.FormFile("fileToUpload")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			requestTooLarge(w, maxRequestSize)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		original, mimeType, ext, err := readUpload(file, fh.Filename, options)
+		if err == nil && isVideoExtension(ext) {
+			err = unsupportedTypeError(mimeType, fh.Filename)
+		}
+		var data []byte
+		if err == nil {
+			data, err = SanitizeImage(original, ext)
+			if err != nil {
+				err = unsupportedTypeError(mimeType, fh.Filename)
+			}
+		}
+		if e, ok := err.(uploadError); ok {
+			w.WriteHeader(e.status)
+			fmt.Fprint(w, e.message)
+			return
+		} else if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+
+		publishAt, err := parsePublishTime(r.FormValue("publishAt"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		cw := templateWeek(now, r.FormValue("week"))
+		if err := checkPublishTime(publishAt, cw, now); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err)
+			return
+		}
+		template, err := source.StoreTemplate(cw, data, ext, r.FormValue("replace"), cleanCaption(r.FormValue("title")), publishAt)
+		if err == errTemplateNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Das Template gibt es nicht")
+			return
+		} else if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		log.Infof("%s uploaded %s for %s", user, template.FileName(), cw.Path())
+
+		if template.Meta.IsScheduled(time.Now()) {
+			time.AfterFunc(time.Until(publishAt), func() {
+				announceTemplate(s, user, *template)
+			})
+		} else {
+			announceTemplate(s, user, *template)
+		}
+
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			err := json.NewEncoder(w).Encode(struct {
+				URL string `json:"url"`
+			}{
+				URL: "/mm/" + filepath.ToSlash(template.Href()),
+			})
+			if err != nil {
+				log.Error(err)
+			}
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
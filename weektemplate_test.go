@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func templateUpload(t *testing.T, user string, data []byte, week string, replace string) *http.Request {
+	body := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("fileToUpload", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.WriteField("week", week)
+	writer.WriteField("replace", replace)
+	writer.WriteField("title", "Drake")
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/template", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.SetBasicAuth(user, "")
+	return req
+}
+
+func TestTemplateWeek(t *testing.T) {
+	now := time.Date(2020, 12, 30, 12, 0, 0, 0, time.UTC)
+	if cw := templateWeek(now, "current"); cw != (CW{Year: 2020, Week: 53}) {
+		t.Errorf("expected 2020/53, got %v", cw)
+	}
+	if cw := templateWeek(now, "next"); cw != (CW{Year: 2021, Week: 1}) {
+		t.Errorf("expected 2021/1, got %v", cw)
+	}
+}
+
+func TestTemplateUpload(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	handler := templateUploadHandler(source, &Subscriptions{}, DefaultUploadOptions, []string{"hans"})
+
+	resp := httptest.NewRecorder()
+	handler(resp, templateUpload(t, "fritz", testPNG(t), "current", ""))
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for other users, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	handler(resp, templateUpload(t, "hans", testPNG(t), "next", ""))
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected status 303, got %d: %s", resp.Code, resp.Body.String())
+	}
+	resp = httptest.NewRecorder()
+	handler(resp, templateUpload(t, "hans", testPNG(t), "next", ""))
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected status 303, got %d: %s", resp.Code, resp.Body.String())
+	}
+	cw := templateWeek(time.Now(), "next")
+	week, err := source.GetMaimaisForCW(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(week.Templates) != 2 || week.Templates[1].FileName() != "template_2.png" || week.Templates[1].Title() != "Drake" {
+		t.Fatalf("expected two png templates, got %+v", week.Templates)
+	}
+
+	// replace it with a jpeg
+	buffer := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buffer, image.NewRGBA(image.Rect(0, 0, 20, 10)), nil); err != nil {
+		t.Fatal(err)
+	}
+	resp = httptest.NewRecorder()
+	handler(resp, templateUpload(t, "hans", buffer.Bytes(), "next", "template_1.png"))
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected status 303, got %d: %s", resp.Code, resp.Body.String())
+	}
+	files, err := filepath.Glob(filepath.Join(string(source), cw.Path(), "template_1.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "template_1.jpg" {
+		t.Errorf("expected only template_1.jpg, got %v", files)
+	}
+
+	// and with a jpeg again
+	resp = httptest.NewRecorder()
+	handler(resp, templateUpload(t, "hans", buffer.Bytes(), "next", "template_1.jpg"))
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected status 303, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(string(source), cw.Path(), "template_1.jpg")); err != nil {
+		t.Errorf("expected template_1.jpg to be kept, got %v", err)
+	}
+
+	resp = httptest.NewRecorder()
+	handler(resp, templateUpload(t, "hans", testPNG(t), "next", "template_9.png"))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for missing template, got %d", resp.Code)
+	}
+	if _, err := os.Stat(filepath.Join(string(source), cw.Path(), ".template.tmp")); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be removed, got %v", err)
+	}
+}
+
+func TestNewTemplate(t *testing.T) {
+	cw := CW{Year: 2021, Week: 3}
+	for fileName, number := range map[string]int{"template.png": 0, "template_1.jpg": 1, "template_12.gif": 12} {
+		template, err := NewTemplate(fileName, cw)
+		if err != nil {
+			t.Errorf("cannot parse %s: %v", fileName, err)
+			continue
+		}
+		if template.Number != number || template.FileName() != fileName {
+			t.Errorf("expected %s to be template %d, got %+v", fileName, number, template)
+		}
+	}
+	for _, fileName := range []string{"template_.png", "3_hans_1.png", "template_a.png"} {
+		if _, err := NewTemplate(fileName, cw); err == nil {
+			t.Errorf("expected %s not to be a template", fileName)
+		}
+	}
+}
+
+func TestIndexTemplateOnlyWeek(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	current, next := templateWeek(time.Now(), "current"), templateWeek(time.Now(), "next")
+	writeTestMaimais(t, source, filepath.Join(current.Path(), "template_1.png"), filepath.Join(next.Path(), "template_2.png"))
+	handler := index(*loadTemplates("templates").Lookup("index.html"), source, &Subscriptions{}, []string{"hans"}, []string{"hans"})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("hans", "")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	body := resp.Body.String()
+	if !strings.Contains(body, `data-cw="`+current.Path()+`"`) || !strings.Contains(body, fmt.Sprintf(`id="template-%d-%d-1"`, current.Year, current.Week)) {
+		t.Error("week with only a template is not shown")
+	}
+	if strings.Contains(body, `data-cw="`+next.Path()+`"`) {
+		t.Error("the next week is shown before it starts")
+	}
+	for _, option := range []string{`value="template_1.png" data-week="current"`, `value="template_2.png" data-week="next"`} {
+		if !strings.Contains(body, option) {
+			t.Errorf("expected template option %s", option)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// wrappedTopVoted is the number of maimais with the most reactions on the wrapped page
+	wrappedTopVoted = 3
+	// wrappedCollage is the number of images in the collage of the wrapped page
+	wrappedCollage = 9
+)
+
+// Wrapped is the review of a year for the group or a user
+type Wrapped struct {
+	Year int
+	// User is empty for the group
+	User UserName
+	// Users are the users with uploads in the year, sorted by name
+	Users     []UserName
+	Maimais   int
+	Reactions int
+	// TopVoted are the maimais with the most reactions, there is no voting
+	TopVoted []UserMaimai
+	// BusiestWeek is the week with the most uploads, it only contains the uploads of the user
+	BusiestWeek *Week
+	// First and Last are the first and last upload of the year
+	First *UserMaimai
+	Last  *UserMaimai
+	// LongestStreak is the longest run of consecutive weeks with uploads
+	LongestStreak int
+	// Collage are the images with the most reactions, videos are left out
+	Collage []UserMaimai
+}
+
+// NewWrapped reviews the weeks of the year for the user or the group if user is empty
+func NewWrapped(weeks []Week, year int, user UserName) Wrapped {
+	wrapped := Wrapped{Year: year, User: user}
+	users := map[UserName]bool{}
+	uploadWeeks := map[CW]bool{}
+	maimais := []UserMaimai{}
+	for _, week := range weeks {
+		own := Week{CW: week.CW}
+		for _, m := range week.Maimais {
+			name := UserName(strings.ToLower(string(m.User)))
+			users[name] = true
+			if len(user) > 0 && name != user {
+				continue
+			}
+			own.Maimais = append(own.Maimais, m)
+		}
+		if len(own.Maimais) == 0 {
+			continue
+		}
+		uploadWeeks[week.CW] = true
+		maimais = append(maimais, own.Maimais...)
+		if wrapped.BusiestWeek == nil || len(own.Maimais) > len(wrapped.BusiestWeek.Maimais) ||
+			(len(own.Maimais) == len(wrapped.BusiestWeek.Maimais) && own.CW.Before(wrapped.BusiestWeek.CW)) {
+			busiest := own
+			wrapped.BusiestWeek = &busiest
+		}
+	}
+	for u := range users {
+		wrapped.Users = append(wrapped.Users, u)
+	}
+	sort.Slice(wrapped.Users, func(i, j int) bool {
+		return wrapped.Users[i] < wrapped.Users[j]
+	})
+	if len(maimais) == 0 {
+		return wrapped
+	}
+
+	// oldest upload first
+	sort.Slice(maimais, func(i, j int) bool {
+		a, b := maimais[i], maimais[j]
+		if a.CW != b.CW {
+			return a.CW.Before(b.CW)
+		}
+		return a.Before(b)
+	})
+	wrapped.Maimais = len(maimais)
+	wrapped.First = &maimais[0]
+	wrapped.Last = &maimais[len(maimais)-1]
+	wrapped.LongestStreak = longestStreak(uploadWeeks, year)
+
+	// most reactions first, the older maimai wins ties
+	best := append([]UserMaimai{}, maimais...)
+	sort.SliceStable(best, func(i, j int) bool {
+		return best[i].Meta.ReactionCount() > best[j].Meta.ReactionCount()
+	})
+	for _, m := range best {
+		wrapped.Reactions += m.Meta.ReactionCount()
+		if len(wrapped.TopVoted) < wrappedTopVoted && m.Meta.ReactionCount() > 0 {
+			wrapped.TopVoted = append(wrapped.TopVoted, m)
+		}
+		if len(wrapped.Collage) < wrappedCollage && !m.IsVideo() {
+			wrapped.Collage = append(wrapped.Collage, m)
+		}
+	}
+	return wrapped
+}
+
+// WrappedCache is the directory the wrapped pages of finished years are cached in
+// Pages are kept per Version, so a new binary or changed templates render them again.
+type WrappedCache struct {
+	Dir     string
+	Version string
+}
+
+// NewWrappedCache creates the cache in dir for the running binary and the templates in templateDir
+// Pages of other versions are removed.
+func NewWrappedCache(dir string, templateDir string) (WrappedCache, error) {
+	hash := sha256.New()
+	files := []string{}
+	if binary, err := os.Executable(); err == nil {
+		files = append(files, binary)
+	}
+	templates, err := filepath.Glob(filepath.Join(templateDir, "*.html"))
+	if err != nil {
+		return WrappedCache{}, err
+	}
+	for _, path := range append(files, templates...) {
+		f, err := os.Open(path)
+		if err != nil {
+			return WrappedCache{}, err
+		}
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return WrappedCache{}, err
+		}
+	}
+	cache := WrappedCache{Dir: dir, Version: hex.EncodeToString(hash.Sum(nil))[:16]}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return WrappedCache{}, err
+	}
+	for _, entry := range entries {
+		if entry.Name() != cache.Version {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				log.Warnf("cannot remove old wrapped pages: %v", err)
+			}
+		}
+	}
+	return cache, nil
+}
+
+// Path is the file the wrapped page of a finished year is cached in
+// The group page is _group.html, user names can't start with an underscore.
+func (c WrappedCache) Path(year int, user UserName) string {
+	name := "_group"
+	if len(user) > 0 {
+		name = string(user)
+	}
+	return filepath.Join(c.Dir, c.Version, strconv.Itoa(year), name+".html")
+}
+
+// yearModTime returns when the maimais of the year changed last
+func yearModTime(source MaimaiSource, year int) (time.Time, error) {
+	folders, err := filepath.Glob(filepath.Join(string(source), strconv.Itoa(year), "CW_*"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := time.Time{}
+	for _, folder := range folders {
+		cw, err := CWFromPath(folder)
+		if err != nil {
+			continue
+		}
+		modTime, err := weekModTime(source, *cw)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if modTime.After(latest) {
+			latest = modTime
+		}
+	}
+	return latest, nil
+}
+
+// weekModTime returns when the maimais of the calender week changed last
+// Uploads change the week folder and reactions, captions etc. the sidecar file.
+func weekModTime(source MaimaiSource, cw CW) (time.Time, error) {
+	latest := time.Time{}
+	folder := filepath.Join(string(source), cw.Path())
+	for _, path := range []string{folder, filepath.Join(folder, metadataFile)} {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// wrappedHandler shows the review of a year for the group (/wrapped/{year}) or a user (/wrapped/{year}/{user})
+// The pages of finished years are rendered once into a file and served from there
+// until the maimais of the year change.
+func wrappedHandler(template template.Template, source MaimaiSource, users []string, wrappedCache WrappedCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		year := getYear(r)
+		user, ok := mux.Vars(r)["user"]
+		if ok && !contains(users, user) {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		if year > Weeks.Current().Year {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		finished := year < Weeks.Current().Year
+		cache := wrappedCache.Path(year, UserName(user))
+		if finished {
+			modTime, err := yearModTime(source, year)
+			if err != nil {
+				log.Error(err)
+				httpError(w, http.StatusInternalServerError)
+				return
+			}
+			if info, err := os.Stat(cache); err == nil && info.ModTime().After(modTime) {
+				f, err := os.Open(cache)
+				if err == nil {
+					defer f.Close()
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					http.ServeContent(w, r, "", info.ModTime(), f)
+					return
+				}
+				log.Error(err)
+			}
+		}
+
+		weeks, err := GetMaimais(source, year)
+		if err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if len(weeks) == 0 {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		wrapped := NewWrapped(weeks, year, UserName(user))
+		if ok && wrapped.Maimais == 0 {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+
+		var page bytes.Buffer
+		if err := template.Execute(&page, wrapped); err != nil {
+			log.Error(err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+		if finished {
+			if err := writeFileAtomic(cache, page.Bytes()); err != nil {
+				log.Error(err)
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if _, err := page.WriteTo(w); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// writeFileAtomic writes the file to a temporary file first and then replaces it
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
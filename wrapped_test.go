@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestNewWrapped(t *testing.T) {
+	upload := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	// GetMaimais returns the newest week first
+	weeks := []Week{
+		{CW: CW{Year: 2021, Week: 4}, Maimais: []UserMaimai{
+			statsMaimai("hans", CW{Year: 2021, Week: 4}, 1, upload, "mp4", 5),
+		}},
+		{CW: CW{Year: 2021, Week: 2}, Maimais: []UserMaimai{
+			statsMaimai("hans", CW{Year: 2021, Week: 2}, 1, upload, "png", 1),
+			statsMaimai("fritz", CW{Year: 2021, Week: 2}, 2, upload, "png", 3),
+			statsMaimai("hans", CW{Year: 2021, Week: 2}, 3, upload, "gif", 0),
+		}},
+		{CW: CW{Year: 2021, Week: 1}, Maimais: []UserMaimai{
+			statsMaimai("fritz", CW{Year: 2021, Week: 1}, 1, upload, "png", 0),
+			statsMaimai("hans", CW{Year: 2021, Week: 1}, 2, upload, "png", 0),
+		}},
+	}
+
+	group := NewWrapped(weeks, 2021, "")
+	if group.Maimais != 6 || group.Reactions != 9 || len(group.Users) != 2 || group.Users[0] != "fritz" {
+		t.Errorf("unexpected totals %d, %d and users %v", group.Maimais, group.Reactions, group.Users)
+	}
+	if group.First.CW.Week != 1 || group.First.User != "fritz" || group.Last.CW.Week != 4 {
+		t.Errorf("unexpected first and last upload %s and %s", group.First.Permalink(), group.Last.Permalink())
+	}
+	if group.BusiestWeek == nil || group.BusiestWeek.CW.Week != 2 || group.LongestStreak != 2 {
+		t.Errorf("expected week 2 as busiest week and a streak of 2 weeks, got %+v and %d", group.BusiestWeek, group.LongestStreak)
+	}
+	if len(group.TopVoted) != 3 || group.TopVoted[0].ImageType != "mp4" || group.TopVoted[2].Meta.ReactionCount() != 1 {
+		t.Errorf("unexpected top voted maimais %v", group.TopVoted)
+	}
+	// videos are not part of the collage
+	if len(group.Collage) != 5 || group.Collage[0].User != "fritz" {
+		t.Errorf("unexpected collage %v", group.Collage)
+	}
+
+	hans := NewWrapped(weeks, 2021, "hans")
+	if hans.Maimais != 4 || hans.Reactions != 6 || len(hans.Users) != 2 {
+		t.Errorf("unexpected totals %d and %d", hans.Maimais, hans.Reactions)
+	}
+	if hans.BusiestWeek.CW.Week != 2 || len(hans.BusiestWeek.Maimais) != 2 {
+		t.Errorf("expected 2 own maimais in the busiest week, got %+v", hans.BusiestWeek)
+	}
+	if hans.First.Counter != 2 || hans.Last.CW.Week != 4 {
+		t.Errorf("unexpected first and last upload %s and %s", hans.First.Permalink(), hans.Last.Permalink())
+	}
+
+	if empty := NewWrapped(weeks, 2021, "franz"); empty.Maimais != 0 || empty.First != nil || empty.BusiestWeek != nil {
+		t.Errorf("expected nothing for a user without uploads, got %+v", empty)
+	}
+}
+
+func TestWrappedHandler(t *testing.T) {
+	source := MaimaiSource(t.TempDir())
+	for _, path := range []string{"2021/CW_01/1_hans_1.png", "2021/CW_02/1_fritz_1.png"} {
+		path = filepath.Join(string(source), path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, testPNG(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the files of the year are older than the cached page
+	past := time.Now().Add(-time.Hour)
+	for _, dir := range []string{"2021/CW_01", "2021/CW_02"} {
+		if err := os.Chtimes(filepath.Join(string(source), dir), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wrappedCache, err := NewWrappedCache(filepath.Join(t.TempDir(), "wrapped"), "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := wrappedHandler(*loadTemplates("templates").Lookup("wrapped.html"), source, []string{"hans", "fritz", "franz"}, wrappedCache)
+	router := mux.NewRouter()
+	router.HandleFunc("/wrapped/{year:202[0-9]}", handler)
+	router.HandleFunc("/wrapped/{year:202[0-9]}/{user:[a-z]+}", handler)
+	get := func(url string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+		return resp
+	}
+
+	resp := get("/wrapped/2021/hans")
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "/2021/CW_01/1") {
+		t.Fatalf("expected the page of hans, got %d", resp.Code)
+	}
+	cache := wrappedCache.Path(2021, "hans")
+	if _, err := os.Stat(cache); err != nil {
+		t.Fatalf("expected the page to be cached: %v", err)
+	}
+	if !strings.HasPrefix(cache, wrappedCache.Dir) || strings.HasPrefix(cache, string(source)) {
+		t.Errorf("expected the page to be cached outside of the maimais, got %s", cache)
+	}
+
+	// the cached page is served as long as the year does not change
+	if err := os.WriteFile(cache, []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := get("/wrapped/2021/hans"); resp.Body.String() != "cached" || len(resp.Header().Get("Last-Modified")) == 0 {
+		t.Errorf("expected the cached page, got %q", resp.Body.String())
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(string(source), "2021/CW_02"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if resp := get("/wrapped/2021/hans"); resp.Body.String() == "cached" {
+		t.Error("expected the page to be rendered again after a change")
+	}
+
+	// a new version does not serve the pages of the old one
+	if err := os.WriteFile(cache, []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := wrappedCache.Path(2021, "hans")
+	wrappedCache.Version = "neu"
+	router.HandleFunc("/neu/wrapped/{year:202[0-9]}/{user:[a-z]+}", wrappedHandler(*loadTemplates("templates").Lookup("wrapped.html"), source, []string{"hans"}, wrappedCache))
+	if resp := get("/neu/wrapped/2021/hans"); resp.Code != http.StatusOK || resp.Body.String() == "cached" {
+		t.Error("expected the page to be rendered again for a new version")
+	}
+	if _, err := NewWrappedCache(wrappedCache.Dir, "templates"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(wrappedCache.Path(2021, "hans")); !os.IsNotExist(err) {
+		t.Errorf("expected the pages of other versions to be removed, got %v", err)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Errorf("expected the pages of the current version to be kept: %v", err)
+	}
+
+	if resp := get("/wrapped/2021"); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "/wrapped/2021/fritz") {
+		t.Errorf("expected the page of the group, got %d", resp.Code)
+	}
+	for _, url := range []string{"/wrapped/2021/franz", "/wrapped/2021/nobody", "/wrapped/2020"} {
+		if resp := get(url); resp.Code != http.StatusNotFound {
+			t.Errorf("expected status 404 for %s, got %d", url, resp.Code)
+		}
+	}
+}